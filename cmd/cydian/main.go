@@ -1,65 +1,225 @@
 package main
 
 import (
-	"log"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/CytonicMC/Cydian/internal/admin"
 	"github.com/CytonicMC/Cydian/internal/app"
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/config"
 	"github.com/CytonicMC/Cydian/internal/env"
 	"github.com/CytonicMC/Cydian/internal/friends"
 	"github.com/CytonicMC/Cydian/internal/handlers"
+	"github.com/CytonicMC/Cydian/internal/health"
+	"github.com/CytonicMC/Cydian/internal/instances"
+	"github.com/CytonicMC/Cydian/internal/logging"
 	"github.com/CytonicMC/Cydian/internal/metrics"
+	"github.com/CytonicMC/Cydian/internal/network"
 	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/CytonicMC/Cydian/internal/presence"
+	"github.com/CytonicMC/Cydian/internal/schedule"
 	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/CytonicMC/Cydian/internal/tracing"
 	"github.com/CytonicMC/Cydian/internal/utils"
-	"github.com/nats-io/nats.go"
+	"github.com/google/uuid"
+	"github.com/hashicorp/nomad/api"
 )
 
+var logger = logging.For("main")
+
 func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal(logger, "failed to load configuration", "err", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Logging.Format, cfg.Logging.Level); err != nil {
+		logging.Fatal(logger, "failed to set up logging", "err", err)
+	}
+	config.OnReload(func(cfg *config.Config) {
+		if err := logging.SetLevel(cfg.Logging.Level); err != nil {
+			logger.Error("failed to change the log level", "err", err)
+		}
+	})
+	go config.Watch(10 * time.Second)
+
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
+	if err != nil {
+		logging.Fatal(logger, "failed to set up tracing", "err", err)
+	}
+
 	// Initialize Prometheus metrics
 	metrics.InitMetrics()
-	metrics.ServeMetrics()
+	metrics.ServeMetrics(cfg.Metrics.Address)
 
 	// Connect to NATS server
-	nc, err := nats.Connect(utils.NatsUrl())
+	nc, err := utils.ConnectNats(cfg.Nats)
 	if err != nil {
-		log.Fatalf("Error connecting to NATS: %v", err)
+		logging.Fatal(logger, "failed to connect to NATS", "err", err)
 	}
 	defer nc.Close()
-	log.Printf("Connected to NATS! (Using url %s)\n", utils.NatsUrl())
+	logger.Info("connected to NATS", "url", nc.ConnectedUrlRedacted())
+
+	if err := audit.Setup(nc, cfg.Audit); err != nil {
+		logging.Fatal(logger, "failed to set up the audit sink", "sink", cfg.Audit.Sink, "err", err)
+	}
 
 	// Initialize the registries
 	serverReg := servers.NewRegistry()
 	friendReg := friends.NewRegistry(nc)
 	partyReg := parties.NewPartyRegistry(nc)
 	partyInviteReg := parties.NewInviteRegistry(nc, partyReg)
+	joinRequestReg := parties.NewJoinRequestRegistry(nc, partyReg)
+
+	var partyEvents *parties.EventStream
+	if stream := cfg.PartyEvents.Stream; stream != "" {
+		var last uint64
+		partyEvents, last, err = parties.NewEventStream(nc, stream, cfg.PartyEvents.MaxAge.D())
+		if err != nil {
+			logging.Fatal(logger, "failed to set up the party event stream", "stream", stream, "err", err)
+		}
+		partyReg.SetEventLog(context.Background(), partyEvents, last)
+		logger.Info("publishing party events", "stream", stream, "sequence", last)
+	}
+
+	nomad, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		logging.Fatal(logger, "failed to create the Nomad client", "err", err)
+	}
+	privateReg := instances.NewPrivateRegistry(nomad, serverReg)
+
+	var scheduleFile *schedule.File
+	if path := cfg.Instances.ScheduleFile; path != "" {
+		scheduleFile, err = schedule.Load(path)
+		if err != nil {
+			logging.Fatal(logger, "failed to load the schedule file", "path", path, "err", err)
+		}
+	}
+	scheduler := schedule.NewScheduler(nc, nomad, scheduleFile)
+	config.OnReload(func(cfg *config.Config) {
+		if cfg.Instances.ScheduleFile == "" {
+			scheduler.SetFile(nil)
+			return
+		}
+		file, err := schedule.Load(cfg.Instances.ScheduleFile)
+		if err != nil {
+			logger.Error("keeping the previous schedule, failed to load the schedule file", "path", cfg.Instances.ScheduleFile, "err", err)
+			return
+		}
+		scheduler.SetFile(file)
+	})
+	maintenance := network.NewMaintenance(nc, nomad)
+	presenceReg := presence.NewRegistry()
+	partyReg.SetRankLookup(func(_ context.Context, player parties.UUID) (string, bool) {
+		p, ok := presenceReg.Get(uuid.UUID(player))
+		if !ok || p.Rank == "" {
+			return "", false
+		}
+		return p.Rank, true
+	})
+	chatRelay := parties.NewChatRelay(nc, partyReg, func(player parties.UUID) (string, bool) {
+		p, ok := presenceReg.Get(uuid.UUID(player))
+		if !ok || p.Server == "" {
+			return "", false
+		}
+		return p.Server, true
+	})
 
 	instance := &app.Cydian{
 		ServerRegistry:        serverReg,
 		FriendRequestRegistry: friendReg,
 		PartyInviteRegistry:   partyInviteReg,
+		JoinRequestRegistry:   joinRequestReg,
 		PartyRegistry:         partyReg,
+		PrivateInstances:      privateReg,
+		Maintenance:           maintenance,
+		Presence:              presenceReg,
+		Nomad:                 nomad,
 	}
+	registerRegistryGauges(instance)
 
 	// Set up handlers
 	handlers.RegisterServers(nc, serverReg)
 	handlers.RegisterFriends(nc, friendReg)
 	handlers.RegisterPartyInvites(nc, partyInviteReg, instance)
+	handlers.RegisterPartyJoinRequests(nc, joinRequestReg)
+	handlers.RegisterPartyChat(nc, chatRelay)
 	handlers.RegisterParties(nc, partyReg)
-	handlers.RegisterInstances(nc)
+	handlers.RegisterInstances(nc, nomad, privateReg)
 	handlers.RegisterPlayerHandlers(nc, instance)
+	handlers.RegisterSchedule(nc, scheduler)
+	handlers.RegisterNetwork(nc, maintenance, serverReg, scheduler, partyReg)
+	handlers.RegisterAdmin(nc)
+	handlers.RegisterHealth(nc)
+	handlers.RegisterAudit(nc)
+	if err := utils.SubscribeProbe(nc); err != nil {
+		logging.Fatal(logger, "failed to subscribe the subscription probe", "err", err)
+	}
+
+	admin.Register(nc, instance)
+
+	// a sweep is overdue once three intervals have passed without one
+	sweepMaxAge := func() time.Duration {
+		cfg := config.Get().Servers
+		return 3 * (cfg.HealthCheckInterval.D() + cfg.HealthCheckTimeout.D())
+	}
+	health.RegisterLiveness("nats", health.NatsClosedCheck(nc))
+	health.RegisterReadiness("nats", health.NatsCheck(nc))
+	health.RegisterReadiness("subscriptions", health.SubscriptionsCheck(nc, func() error {
+		return utils.ProbeSubscriptions(nc, 2*time.Second)
+	}))
+	health.RegisterReadiness("nomad", health.NomadCheck(nomad, 2*time.Second))
+	health.RegisterReadiness("health_check_sweep", health.SweepCheck(serverReg.LastHealthCheck, sweepMaxAge, time.Now()))
+	if partyEvents != nil {
+		health.RegisterReadiness("persistence", health.EventStreamCheck(partyEvents.Err, partyEvents.Pending))
+	} else {
+		health.RegisterReadiness("persistence", health.PersistenceCheck)
+	}
+	health.RegisterReadiness("maintenance_state", health.BucketCheck(maintenance.Persistence, 2*time.Second))
+	health.RegisterReadiness("schedule_state", health.BucketCheck(scheduler.Persistence, 2*time.Second))
+	health.Serve()
+
+	go scheduler.Run(time.Minute)
 
-	// Periodic cleanup of stale servers
+	// Periodic cleanup of stale servers, re-reading the interval so reloads apply
+	go func() {
+		for {
+			time.Sleep(config.Get().Servers.HealthCheckInterval.D())
+			serverReg.HealthCheck(nc, config.Get().Servers.HealthCheckTimeout.D())
+		}
+	}()
+
+	// Tear down private instances that have been left empty
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
 
 		for range ticker.C {
-			serverReg.HealthCheck(nc, 5*time.Second) // 5-second timeout per server
+			privateReg.Reap()
 		}
 	}()
 
-	// Keep the service running
-	log.Printf("Started Cydian in environment %s\n", env.Environment())
-	select {}
+	// Keep the service running until asked to stop
+	logger.Info("started Cydian", "environment", env.Environment())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	logger.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("failed to flush traces", "err", err)
+	}
+	if partyEvents != nil {
+		if err := partyEvents.Close(ctx); err != nil {
+			logger.Error("failed to flush party events", "err", err)
+		}
+	}
+	if err := audit.Close(); err != nil {
+		logger.Error("failed to close the audit sink", "err", err)
+	}
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/CytonicMC/Cydian/internal/app"
+	"github.com/CytonicMC/Cydian/internal/metrics"
+)
+
+// registerRegistryGauges exposes the size of every registry, read at scrape time
+func registerRegistryGauges(cydian *app.Cydian) {
+	metrics.RegisterGaugeFunc("cydian_servers", "Registered servers by type and status",
+		[]string{"type", "status"}, func() []metrics.Sample {
+			counts := make(map[[2]string]int)
+			for _, s := range cydian.ServerRegistry.GetAll() {
+				status := "active"
+				if s.Draining {
+					status = "draining"
+				}
+				counts[[2]string{s.Type, status}]++
+			}
+			samples := make([]metrics.Sample, 0, len(counts))
+			for key, n := range counts {
+				samples = append(samples, metrics.Sample{Labels: key[:], Value: float64(n)})
+			}
+			return samples
+		})
+
+	metrics.RegisterGaugeFunc("cydian_parties", "Active parties by member count",
+		[]string{"size"}, func() []metrics.Sample {
+			counts := cydian.PartyRegistry.SizeCounts()
+			samples := make([]metrics.Sample, 0, len(counts))
+			for size, n := range counts {
+				samples = append(samples, metrics.Sample{Labels: []string{strconv.Itoa(size)}, Value: float64(n)})
+			}
+			return samples
+		})
+
+	metrics.RegisterGaugeFunc("cydian_party_invites", "Outstanding party invites",
+		nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(len(cydian.PartyInviteRegistry.GetAll()))}}
+		})
+
+	metrics.RegisterGaugeFunc("cydian_party_join_requests", "Pending requests to join closed parties",
+		nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(len(cydian.JoinRequestRegistry.GetAll()))}}
+		})
+
+	metrics.RegisterGaugeFunc("cydian_friend_requests", "Pending friend requests",
+		nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(len(cydian.FriendRequestRegistry.GetAll()))}}
+		})
+
+	metrics.RegisterGaugeFunc("cydian_party_disconnect_timers", "Disconnected party members waiting out their grace period",
+		nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(cydian.PartyRegistry.PendingDisconnects())}}
+		})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/friends"
+	"github.com/CytonicMC/Cydian/internal/instances"
+	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/google/uuid"
+)
+
+// result is the common shape of Cydian's {success, message} replies
+type result struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+func (c *ctl) run(args []string) error {
+	group, command, rest := args[0], args[1], args[2:]
+	switch group + " " + command {
+	case "servers ls":
+		return c.serversList()
+	case "servers drain":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: servers drain <id>")
+		}
+		return c.simple("servers.drain", servers.ServerInfo{ID: rest[0]})
+	case "parties ls":
+		return c.partiesList("")
+	case "parties show":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: parties show <player>")
+		}
+		return c.partiesList(rest[0])
+	case "parties disband":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: parties disband <party>")
+		}
+		id, err := parseUUID(rest[0])
+		if err != nil {
+			return err
+		}
+		return c.simple("party.disband.force.request", parties.PartyOnePlayerPacket{PartyID: parties.UUID(id)})
+	case "instances scale":
+		if len(rest) != 2 {
+			return fmt.Errorf("usage: instances scale <type> <count>")
+		}
+		count, err := strconv.Atoi(rest[1])
+		if err != nil || count < 0 {
+			return fmt.Errorf("invalid count %q", rest[1])
+		}
+		return c.simple("servers.scale", instances.InstanceScaleRequest{InstanceType: rest[0], Count: count})
+	case "friends pending":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: friends pending <player>")
+		}
+		id, err := parseUUID(rest[0])
+		if err != nil {
+			return err
+		}
+		return c.friendsPending(id)
+	case "audit player", "audit party":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: audit %s <id>", command)
+		}
+		id, err := parseUUID(rest[0])
+		if err != nil {
+			return err
+		}
+		if command == "player" {
+			return c.auditList(audit.Query{Player: id.String()})
+		}
+		return c.auditList(audit.Query{Party: id.String()})
+	default:
+		return fmt.Errorf("unknown command %q, see --help", group+" "+command)
+	}
+}
+
+// simple sends a request answered with {success, message}
+func (c *ctl) simple(subject string, packet any) error {
+	data, err := c.request(subject, packet)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+	var res result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return fmt.Errorf("invalid reply: %s", data)
+	}
+	if !res.Success {
+		return fmt.Errorf("failed: %s", res.Message)
+	}
+	fmt.Fprintln(c.out, "OK")
+	return nil
+}
+
+func (c *ctl) serversList() error {
+	data, err := c.request("servers.list", nil)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+	var list servers.ServerList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("invalid reply: %s", data)
+	}
+	rows := make([]string, 0, len(list.Servers))
+	for _, s := range list.Servers {
+		lastSeen := "-"
+		if s.LastSeen != nil {
+			lastSeen = time.Since(*s.LastSeen).Round(time.Second).String() + " ago"
+		}
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s:%d\t%t\t%s", s.ID, s.Type, s.IP, s.Port, s.Draining, lastSeen))
+	}
+	c.table("ID\tTYPE\tADDRESS\tDRAINING\tLAST SEEN", rows)
+	return nil
+}
+
+// partiesList prints every party, or only the party of the given player
+func (c *ctl) partiesList(player string) error {
+	var request parties.PartyFetchRequest
+	if player != "" {
+		id, err := parseUUID(player)
+		if err != nil {
+			return err
+		}
+		request.PlayerID = (*parties.UUID)(&id)
+	}
+	data, err := c.request("party.fetch.request", request)
+	if err != nil {
+		return err
+	}
+	var all []parties.Party
+	if err := json.Unmarshal(data, &all); err != nil {
+		return fmt.Errorf("invalid reply: %s", data)
+	}
+	if player != "" && len(all) == 0 {
+		return fmt.Errorf("%s is not in a party", player)
+	}
+
+	if c.json {
+		encoded, err := json.Marshal(all)
+		if err != nil {
+			return err
+		}
+		return c.printJSON(encoded)
+	}
+	rows := make([]string, 0, len(all))
+	for _, p := range all {
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%d\t%s\t%s\t%t\t%t",
+			uuid.UUID(p.ID), uuid.UUID(p.CurrentLeader), p.TotalSize(),
+			joinUUIDs(p.Moderators.Slice()), joinUUIDs(p.Members.Slice()), p.Open, p.Muted))
+	}
+	c.table("ID\tLEADER\tSIZE\tMODERATORS\tMEMBERS\tOPEN\tMUTED", rows)
+	return nil
+}
+
+func (c *ctl) friendsPending(player uuid.UUID) error {
+	data, err := c.request("friends.pending", friends.FriendPendingRequest{Player: player})
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+	var pending friends.FriendPendingResponse
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return fmt.Errorf("invalid reply: %s", data)
+	}
+	rows := make([]string, 0, len(pending.Incoming)+len(pending.Outgoing))
+	for _, r := range pending.Incoming {
+		rows = append(rows, fmt.Sprintf("incoming\t%s\t%s", r.Sender, time.Until(r.Expiry).Round(time.Second)))
+	}
+	for _, r := range pending.Outgoing {
+		rows = append(rows, fmt.Sprintf("outgoing\t%s\t%s", r.Recipient, time.Until(r.Expiry).Round(time.Second)))
+	}
+	c.table("DIRECTION\tPLAYER\tEXPIRES IN", rows)
+	return nil
+}
+
+func (c *ctl) auditList(query audit.Query) error {
+	data, err := c.request("audit.query", query)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+	var response audit.QueryResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("invalid reply: %s", data)
+	}
+	if !response.Success {
+		return fmt.Errorf("failed: %s", response.Message)
+	}
+	rows := make([]string, 0, len(response.Entries))
+	for _, e := range response.Entries {
+		target := e.Target
+		if target == "" {
+			target = "-"
+		}
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%s\t%s",
+			e.Time.Local().Format(time.DateTime), e.Action, e.Actor, target, e.Result))
+	}
+	c.table("TIME\tACTION\tACTOR\tTARGET\tRESULT", rows)
+	return nil
+}
+
+func parseUUID(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	return id, nil
+}
+
+func joinUUIDs(ids []parties.UUID) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, uuid.UUID(id).String())
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,126 @@
+// cydianctl is an operator tool that speaks Cydian's NATS protocol.
+//
+//	cydianctl [--env alpha] [--json] [--timeout 5s] <command> [args...]
+//
+// It connects using the same configuration file and environment variables as Cydian itself.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/nats-io/nats.go"
+)
+
+const usage = `Usage: cydianctl [flags] <command> [args...]
+
+Commands:
+  servers ls                   list registered servers
+  servers drain <id>           stop sending new players to a server
+  parties ls                   list every party
+  parties show <player>        show the party a player is in
+  parties disband <party>      force disband a party
+  instances scale <type> <n>   set the instance count of a server type
+  friends pending <player>     list a player's pending friend requests
+  audit player <player>        show recent audit entries by or about a player
+  audit party <party>          show recent audit entries of a party
+
+Flags:
+`
+
+// ctl carries the connection and output settings shared by every command
+type ctl struct {
+	nc      *nats.Conn
+	json    bool
+	timeout time.Duration
+	out     io.Writer
+}
+
+func main() {
+	flags := flag.NewFlagSet("cydianctl", flag.ExitOnError)
+	environment := flags.String("env", "", "environment whose subjects to use (development, alpha, production)")
+	jsonOutput := flags.Bool("json", false, "print raw JSON instead of tables")
+	timeout := flags.Duration("timeout", 5*time.Second, "how long to wait for a reply")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *environment != "" {
+		// env.EnsurePrefixed reads this on every call
+		_ = os.Setenv("CYTONIC_ENVIRONMENT", *environment)
+	}
+
+	// keep Cydian's own log lines out of the command output
+	_ = logging.Setup(io.Discard, "text", "error")
+	cfg, err := config.Load()
+	if err != nil {
+		fail("loading configuration: %v", err)
+	}
+	nc, err := utils.ConnectNats(cfg.Nats)
+	if err != nil {
+		fail("connecting to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	c := &ctl{nc: nc, json: *jsonOutput, timeout: *timeout, out: os.Stdout}
+	if err := c.run(flags.Args()); err != nil {
+		fail("%v", err)
+	}
+}
+
+// request sends the packet to the environment-prefixed subject and returns the raw reply
+func (c *ctl) request(subject string, packet any) ([]byte, error) {
+	var data []byte
+	if packet != nil {
+		var err error
+		if data, err = json.Marshal(packet); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := c.nc.Request(env.EnsurePrefixed(subject), data, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", env.EnsurePrefixed(subject), err)
+	}
+	return msg.Data, nil
+}
+
+// printJSON pretty prints a raw reply
+func (c *ctl) printJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("invalid reply: %s", data)
+	}
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// table prints tab separated rows with aligned columns
+func (c *ctl) table(header string, rows []string) {
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, row := range rows {
+		fmt.Fprintln(w, row)
+	}
+	_ = w.Flush()
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "cydianctl: "+format+"\n", args...)
+	os.Exit(1)
+}
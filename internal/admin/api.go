@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/app"
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/handlers"
+	"github.com/CytonicMC/Cydian/internal/instances"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+var logger = logging.For("admin")
+
+type Response struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type ScaleRequest struct {
+	Count int `json:"count"`
+}
+
+// Register adds the admin API to the HTTP server that also serves /metrics. Every route requires the
+// configured bearer token, and the write operations go through the same registry methods as the NATS handlers.
+func Register(nc *nats.Conn, cydian *app.Cydian) {
+	route := func(pattern string, handler http.HandlerFunc) {
+		http.Handle(pattern, authenticated(handler))
+	}
+
+	route("GET /admin/servers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cydian.ServerRegistry.GetAll())
+	})
+	route("GET /admin/parties", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cydian.PartyRegistry.GetAllParties())
+	})
+	route("GET /admin/parties/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var id parties.UUID
+		if err := id.UnmarshalText([]byte(r.PathValue("id"))); err != nil {
+			writeJSON(w, http.StatusBadRequest, Response{Message: "ERR_INVALID_UUID"})
+			return
+		}
+		party := cydian.PartyRegistry.GetParty(id)
+		if party == nil {
+			writeJSON(w, http.StatusNotFound, Response{Message: "ERR_INVALID_PARTY"})
+			return
+		}
+		writeJSON(w, http.StatusOK, party)
+	})
+	route("GET /admin/invites", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cydian.PartyInviteRegistry.GetAll())
+	})
+	route("GET /admin/friends/requests", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cydian.FriendRequestRegistry.GetAll())
+	})
+	route("GET /admin/presence", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cydian.Presence.GetAll())
+	})
+	route("GET /admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		query, ok := parseAuditQuery(r)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, Response{Message: "INVALID_QUERY"})
+			return
+		}
+		entries, err := audit.Search(query)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "failed to query the audit trail", "err", err)
+			writeJSON(w, http.StatusInternalServerError, Response{Message: "ERR_AUDIT_UNAVAILABLE"})
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+
+	route("POST /admin/parties/{id}/disband", func(w http.ResponseWriter, r *http.Request) {
+		var id parties.UUID
+		if err := id.UnmarshalText([]byte(r.PathValue("id"))); err != nil {
+			writeJSON(w, http.StatusBadRequest, Response{Message: "ERR_INVALID_UUID"})
+			return
+		}
+		success, reason := cydian.PartyRegistry.ForceDisband(requestContext(r), id)
+		writeResult(w, success, reason)
+	})
+	route("POST /admin/servers/{id}/evict", func(w http.ResponseWriter, r *http.Request) {
+		server, ok := cydian.ServerRegistry.Get(r.PathValue("id"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, Response{Message: "SERVER_NOT_FOUND"})
+			return
+		}
+		cydian.ServerRegistry.Remove(server.ID)
+		handlers.NotifyProxiesOfShutdown(requestContext(r), nc, *server)
+		writeResult(w, true, "")
+	})
+	route("POST /admin/servers/{id}/drain", func(w http.ResponseWriter, r *http.Request) {
+		if !handlers.DrainServer(requestContext(r), nc, cydian.ServerRegistry, r.PathValue("id")) {
+			writeJSON(w, http.StatusNotFound, Response{Message: "SERVER_NOT_FOUND"})
+			return
+		}
+		writeResult(w, true, "")
+	})
+	route("POST /admin/instances/{type}/scale", func(w http.ResponseWriter, r *http.Request) {
+		var req ScaleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Count < 0 {
+			writeJSON(w, http.StatusBadRequest, Response{Message: "INVALID_MESSAGE_FORMAT"})
+			return
+		}
+		errMsg := instances.ScaleTo(requestContext(r), cydian.Nomad, r.PathValue("type"), req.Count, "Scaled through the admin API")
+		writeResult(w, len(errMsg) == 0, errMsg)
+	})
+
+	logger.Info("serving the admin API", "path", "/admin/")
+}
+
+// requestContext carries the caller's correlation ID, or a new one, into the notifications a request causes,
+// and marks the audit entries it records as coming from the admin API
+func requestContext(r *http.Request) context.Context {
+	ctx := audit.WithActor(r.Context(), audit.ActorAdmin)
+	if id := r.Header.Get(logging.CorrelationHeader); id != "" {
+		return logging.WithCorrelationID(ctx, id)
+	}
+	return logging.WithCorrelationID(ctx, uuid.NewString())
+}
+
+// parseAuditQuery reads the player, party, since, until (RFC 3339) and limit query parameters
+func parseAuditQuery(r *http.Request) (audit.Query, bool) {
+	params := r.URL.Query()
+	query := audit.Query{Player: params.Get("player"), Party: params.Get("party")}
+	for name, dst := range map[string]**time.Time{"since": &query.Since, "until": &query.Until} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return query, false
+			}
+			*dst = &t
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return query, false
+		}
+		query.Limit = limit
+	}
+	return query, true
+}
+
+// authenticated rejects requests without the configured bearer token. The token is read on every
+// request so it can be rotated with a configuration reload.
+func authenticated(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := config.Get().Admin.Token
+		if token == "" {
+			writeJSON(w, http.StatusServiceUnavailable, Response{Message: "ERR_ADMIN_API_DISABLED"})
+			return
+		}
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, Response{Message: "ERR_UNAUTHORIZED"})
+			return
+		}
+		next(w, r)
+	})
+}
+
+func writeResult(w http.ResponseWriter, success bool, reason string) {
+	if !success {
+		writeJSON(w, http.StatusConflict, Response{Success: false, Message: reason})
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{Success: true, Message: "SUCCESS"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("failed to write admin API response", "err", err)
+	}
+}
@@ -2,13 +2,22 @@ package app
 
 import (
 	"github.com/CytonicMC/Cydian/internal/friends"
+	"github.com/CytonicMC/Cydian/internal/instances"
+	"github.com/CytonicMC/Cydian/internal/network"
 	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/CytonicMC/Cydian/internal/presence"
 	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/hashicorp/nomad/api"
 )
 
 type Cydian struct {
 	ServerRegistry        *servers.Registry
 	FriendRequestRegistry *friends.Registry
 	PartyInviteRegistry   *parties.InviteRegistry
+	JoinRequestRegistry   *parties.JoinRequestRegistry
 	PartyRegistry         *parties.PartyRegistry
+	PrivateInstances      *instances.PrivateRegistry
+	Maintenance           *network.Maintenance
+	Presence              *presence.Registry
+	Nomad                 *api.Client
 }
@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+var logger = logging.For("audit")
+
+// Actors for entries that weren't caused by a player
+const (
+	ActorSystem  = "system"  // timers, sweeps and the scheduler
+	ActorStaff   = "staff"   // staff tooling talking to Cydian over the admin NATS subjects
+	ActorAdmin   = "admin"   // the admin HTTP API
+	ActorUnknown = "unknown" // any other NATS request, the sender can't be told apart
+)
+
+// ResultSuccess is recorded for operations that went through, failures record their error code
+const ResultSuccess = "SUCCESS"
+
+// Entry is one state-changing operation. Player, party and instance IDs are stored as strings
+// so every subsystem can share the trail.
+type Entry struct {
+	ID            uuid.UUID      `json:"id"`
+	Time          time.Time      `json:"time"`
+	Actor         string         `json:"actor"`  // a player UUID or one of the Actor constants
+	Action        string         `json:"action"` // ie: party.kick
+	Target        string         `json:"target,omitempty"`
+	Party         string         `json:"party,omitempty"`
+	Params        map[string]any `json:"params,omitempty"`
+	Result        string         `json:"result"`
+	CorrelationID string         `json:"correlation_id,omitempty"`
+}
+
+// Sink stores entries. Append must not block on the network, it is called while registries hold their locks.
+type Sink interface {
+	Append(entry Entry) error
+	Query(query Query) ([]Entry, error)
+	Close() error
+}
+
+// Query filters the trail. Zero values match everything.
+type Query struct {
+	Player string     `json:"player,omitempty"` // matches the actor or the target
+	Party  string     `json:"party,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Limit  int        `json:"limit,omitempty"` // the most recent entries are kept, 100 by default
+}
+
+type QueryResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Entries []Entry `json:"entries"`
+}
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Matches reports whether the entry passes every filter of the query
+func (q Query) Matches(entry Entry) bool {
+	if q.Player != "" && entry.Actor != q.Player && entry.Target != q.Player {
+		return false
+	}
+	if q.Party != "" && entry.Party != q.Party {
+		return false
+	}
+	if q.Since != nil && entry.Time.Before(*q.Since) {
+		return false
+	}
+	if q.Until != nil && entry.Time.After(*q.Until) {
+		return false
+	}
+	return true
+}
+
+// limit sorts the matches oldest first and keeps the most recent ones
+func (q Query) limit(entries []Entry) []Entry {
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		return a.Time.Compare(b.Time)
+	})
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	limit = min(limit, maxLimit)
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+var (
+	mu   sync.RWMutex
+	sink Sink = NewMemorySink(10000)
+)
+
+// Setup replaces the default in-memory sink with the configured one
+func Setup(nc *nats.Conn, cfg config.AuditConfig) error {
+	var s Sink
+	var err error
+	switch cfg.Sink {
+	case "memory":
+		s = NewMemorySink(cfg.MemoryEntries)
+	case "file":
+		s, err = NewFileSink(cfg.File)
+	case "jetstream":
+		s, err = NewJetStreamSink(nc, cfg.Stream, cfg.MaxAge.D())
+	}
+	if err != nil {
+		return err
+	}
+	SetSink(s)
+	logger.Info("writing the audit trail", "sink", cfg.Sink)
+	return nil
+}
+
+// SetSink swaps the sink, closing the previous one
+func SetSink(s Sink) {
+	mu.Lock()
+	old := sink
+	sink = s
+	mu.Unlock()
+	if err := old.Close(); err != nil {
+		logger.Error("failed to close the previous audit sink", "err", err)
+	}
+}
+
+// Close flushes and closes the active sink
+func Close() error {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sink.Close()
+}
+
+// Record stamps the entry with an ID, the current time and the correlation ID of ctx, then appends it.
+// A failing sink is logged rather than failing the operation that was audited.
+func Record(ctx context.Context, entry Entry) {
+	entry.ID = uuid.New()
+	entry.Time = time.Now().UTC()
+	entry.CorrelationID = logging.CorrelationID(ctx)
+	if entry.Result == "" {
+		entry.Result = ResultSuccess
+	}
+
+	mu.RLock()
+	err := sink.Append(entry)
+	mu.RUnlock()
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to record audit entry", "action", entry.Action, "err", err)
+	}
+}
+
+// Search runs the query against the active sink
+func Search(query Query) ([]Entry, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	entries, err := sink.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return query.limit(entries), nil
+}
+
+type actorKey struct{}
+
+// WithActor overrides the actor recorded for operations that don't have a player behind them
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor set by WithActor, or fallback
+func Actor(ctx context.Context, fallback string) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return fallback
+}
+
+// Result turns the (success, error code) pair most registry methods return into an entry result
+func Result(success bool, code string) string {
+	if success {
+		return ResultSuccess
+	}
+	if code == "" {
+		return "FAILED"
+	}
+	return code
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemorySinkQuery(t *testing.T) {
+	start := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	minute := func(n int) *time.Time {
+		at := start.Add(time.Duration(n) * time.Minute)
+		return &at
+	}
+
+	// appended out of order, the way concurrent Record calls may land
+	entries := []Entry{
+		{Action: "party.create", Actor: "alice", Party: "p1", Time: *minute(0)},
+		{Action: "party.invite", Actor: "alice", Target: "bob", Party: "p1", Time: *minute(2)},
+		{Action: "party.join", Actor: "bob", Party: "p1", Time: *minute(1)},
+		{Action: "party.kick", Actor: "alice", Target: "bob", Party: "p1", Time: *minute(3)},
+		{Action: "party.create", Actor: "carol", Party: "p2", Time: *minute(4)},
+		{Action: "instances.scale", Actor: ActorSystem, Target: "lobby", Time: *minute(5)},
+	}
+	sink := NewMemorySink(len(entries))
+	for _, entry := range entries {
+		if err := sink.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   Query
+		actions []string // the matches, oldest first
+	}{
+		{"everything", Query{}, []string{"party.create", "party.join", "party.invite", "party.kick", "party.create", "instances.scale"}},
+		{"a player as actor or target", Query{Player: "bob"}, []string{"party.join", "party.invite", "party.kick"}},
+		{"a party", Query{Party: "p2"}, []string{"party.create"}},
+		{"a player in a party", Query{Player: "alice", Party: "p1"}, []string{"party.create", "party.invite", "party.kick"}},
+		{"since, inclusive", Query{Since: minute(3)}, []string{"party.kick", "party.create", "instances.scale"}},
+		{"until, inclusive", Query{Until: minute(1)}, []string{"party.create", "party.join"}},
+		{"a time range", Query{Party: "p1", Since: minute(1), Until: minute(2)}, []string{"party.join", "party.invite"}},
+		{"the most recent within the limit", Query{Player: "alice", Limit: 2}, []string{"party.invite", "party.kick"}},
+		{"a system target", Query{Player: "lobby"}, []string{"instances.scale"}},
+		{"no matches", Query{Player: "dave"}, []string{}},
+	}
+	for _, test := range tests {
+		matches, err := sink.Query(test.query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		actions := make([]string, 0)
+		for _, entry := range test.query.limit(matches) {
+			actions = append(actions, entry.Action)
+		}
+		if !slices.Equal(actions, test.actions) {
+			t.Errorf("%s: got %v, want %v", test.name, actions, test.actions)
+		}
+	}
+
+	// the oldest appended entry is dropped past the cap
+	if err := sink.Append(Entry{Action: "party.leave", Actor: "bob", Party: "p1", Time: *minute(6)}); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := sink.Query(Query{Player: "alice"})
+	if len(matches) != 2 {
+		t.Errorf("got %d entries by alice, want 2 once the first was evicted", len(matches))
+	}
+}
@@ -0,0 +1,276 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/metrics"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// MemorySink keeps the most recent entries in memory. It is the default, so a fresh instance can
+// still answer audit.query, but the trail is lost on restart.
+type MemorySink struct {
+	mu      sync.Mutex
+	entries []Entry
+	max     int
+}
+
+func NewMemorySink(max int) *MemorySink {
+	return &MemorySink{max: max}
+}
+
+func (s *MemorySink) Append(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > s.max {
+		s.entries = s.entries[len(s.entries)-s.max:]
+	}
+	return nil
+}
+
+func (s *MemorySink) Query(query Query) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matches := make([]Entry, 0)
+	for _, entry := range s.entries {
+		if query.Matches(entry) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// FileSink appends entries to a file as JSON lines. Queries scan the whole file.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit file: %w", err)
+	}
+	return &FileSink{file: file, path: path}, nil
+}
+
+func (s *FileSink) Append(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *FileSink) Query(query Query) ([]Entry, error) {
+	// hold the lock so a half-written line is never read
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	matches := make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warn("skipping unreadable audit line", "path", s.path, "err", err)
+			continue
+		}
+		if query.Matches(entry) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, scanner.Err()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// jetStreamQueueSize bounds the entries waiting to be published, Append drops entries past it
+const jetStreamQueueSize = 4096
+
+// JetStreamSink publishes entries to a stream, one subject per action. Append only queues the entry, a
+// single goroutine publishes them, so a slow stream never holds up the registry locks Append is called under.
+// Failures are logged when the acknowledgement doesn't arrive.
+type JetStreamSink struct {
+	js      jetstream.JetStream
+	stream  string
+	subject string
+	queue   chan *nats.Msg
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewJetStreamSink(nc *nats.Conn, stream string, maxAge time.Duration) (*JetStreamSink, error) {
+	js, err := jetstream.New(nc, jetstream.WithPublishAsyncErrHandler(func(_ jetstream.JetStream, msg *nats.Msg, err error) {
+		logger.Error("failed to store audit entry", "subject", msg.Subject, "err", err)
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JetStreamSink{
+		js:      js,
+		stream:  env.EnsurePrefixed(stream),
+		subject: env.EnsurePrefixed("audit.entries"),
+		queue:   make(chan *nats.Msg, jetStreamQueueSize),
+		done:    make(chan struct{}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     s.stream,
+		Subjects: []string{s.subject + ".>"},
+		Storage:  jetstream.FileStorage,
+		MaxAge:   maxAge,
+		// entries are never edited or removed by hand
+		DenyDelete: true,
+		DenyPurge:  true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating audit stream %s: %w", s.stream, err)
+	}
+	go s.run()
+	return s, nil
+}
+
+// Append queues the entry. When the queue is full the entry is dropped and counted.
+func (s *JetStreamSink) Append(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(s.subject + "." + entry.Action)
+	msg.Data = data
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("the audit sink is closed")
+	}
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+		metrics.AuditDropped.Inc()
+		return errors.New("the audit queue is full, dropping entry")
+	}
+}
+
+// run publishes queued entries until the queue is closed. PublishMsgAsync may block on the pending
+// acknowledgement limit, which only holds up this goroutine.
+func (s *JetStreamSink) run() {
+	defer close(s.done)
+	for msg := range s.queue {
+		if _, err := s.js.PublishMsgAsync(msg); err != nil {
+			logger.Error("failed to store audit entry", "subject", msg.Subject, "err", err)
+		}
+	}
+}
+
+// Query replays the stream from Since, or the beginning, up to the last entry at the time of the call
+func (s *JetStreamSink) Query(query Query) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stream, err := s.js.Stream(ctx, s.stream)
+	if err != nil {
+		return nil, err
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]Entry, 0)
+	last := info.State.LastSeq
+	if info.State.Msgs == 0 {
+		return matches, nil
+	}
+
+	cfg := jetstream.OrderedConsumerConfig{}
+	if query.Since != nil {
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = query.Since
+	}
+	consumer, err := stream.OrderedConsumer(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		batch, err := consumer.Fetch(256, jetstream.FetchMaxWait(time.Second))
+		if err != nil {
+			return nil, err
+		}
+		received := 0
+		for msg := range batch.Messages() {
+			received++
+			var entry Entry
+			if err := json.Unmarshal(msg.Data(), &entry); err == nil && query.Matches(entry) {
+				matches = append(matches, entry)
+			}
+			if meta, err := msg.Metadata(); err == nil && meta.Sequence.Stream >= last {
+				return matches, nil
+			}
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			return nil, err
+		}
+		if received == 0 {
+			// nothing left after Since
+			return matches, nil
+		}
+	}
+}
+
+// Close waits briefly for the queued entries to be published and acknowledged. Later entries are refused.
+func (s *JetStreamSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	timeout := time.After(5 * time.Second)
+	select {
+	case <-s.done:
+	case <-timeout:
+		return fmt.Errorf("timed out with %d audit entries still queued", len(s.queue))
+	}
+	select {
+	case <-s.js.PublishAsyncComplete():
+		return nil
+	case <-timeout:
+		return errors.New("timed out waiting for audit entries to be stored")
+	}
+}
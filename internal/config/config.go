@@ -0,0 +1,468 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"go.yaml.in/yaml/v2"
+)
+
+var logger = logging.For("config")
+
+// Config is Cydian's configuration. It is read from a YAML file (CYDIAN_CONFIG, cydian.yml by default),
+// then environment variables are applied on top. Settings marked reloadable are picked up without a
+// restart, the rest only take effect on startup.
+type Config struct {
+	Metrics     MetricsConfig     `yaml:"metrics" json:"metrics"`
+	Nats        NatsConfig        `yaml:"nats" json:"nats"`
+	Servers     ServersConfig     `yaml:"servers" json:"servers"`
+	Parties     PartiesConfig     `yaml:"parties" json:"parties"`
+	Instances   InstancesConfig   `yaml:"instances" json:"instances"`
+	Maintenance MaintenanceConfig `yaml:"maintenance" json:"maintenance"`
+	Admin       AdminConfig       `yaml:"admin" json:"admin"`
+	Logging     LoggingConfig     `yaml:"logging" json:"logging"`
+	Tracing     TracingConfig     `yaml:"tracing" json:"tracing"`
+	Audit       AuditConfig       `yaml:"audit" json:"audit"`
+	PartyEvents PartyEventsConfig `yaml:"party_events" json:"party_events"`
+}
+
+type MetricsConfig struct {
+	Address string `yaml:"address" json:"address"` // CYDIAN_METRICS_ADDRESS
+}
+
+type NatsConfig struct {
+	// Servers are the seed URLs, ie: ["tls://nats-1:4222", "tls://nats-2:4222"]. NATS_SERVERS, comma separated.
+	// When empty, a single nats://hostname:port URL is used.
+	Servers  []string `yaml:"servers" json:"servers"`
+	Hostname string   `yaml:"hostname" json:"hostname"` // NATS_HOSTNAME
+	Port     string   `yaml:"port" json:"port"`         // NATS_PORT
+	Username string   `yaml:"username" json:"username"` // NATS_USERNAME
+	Password string   `yaml:"password" json:"-"`        // NATS_PASSWORD
+	// CredsFile is a .creds file (JWT + nkey seed). NATS_CREDS_FILE
+	CredsFile string `yaml:"creds_file" json:"creds_file"`
+	// NkeySeedFile is a plain nkey seed file. NATS_NKEY_SEED_FILE
+	NkeySeedFile  string        `yaml:"nkey_seed_file" json:"nkey_seed_file"`
+	TLS           NatsTLSConfig `yaml:"tls" json:"tls"`
+	ReconnectWait Duration      `yaml:"reconnect_wait" json:"reconnect_wait"`
+	MaxReconnects int           `yaml:"max_reconnects" json:"max_reconnects"` // -1 reconnects forever
+}
+
+type NatsTLSConfig struct {
+	CAFile   string `yaml:"ca_file" json:"ca_file"`     // NATS_TLS_CA_FILE
+	CertFile string `yaml:"cert_file" json:"cert_file"` // NATS_TLS_CERT_FILE
+	KeyFile  string `yaml:"key_file" json:"key_file"`   // NATS_TLS_KEY_FILE
+}
+
+// AdminConfig is reloadable
+type AdminConfig struct {
+	// Token is the bearer token required by the admin API, which is disabled while it is empty. CYDIAN_ADMIN_TOKEN
+	Token string `yaml:"token" json:"-"`
+}
+
+// LoggingConfig's level is reloadable, the format only applies on startup
+type LoggingConfig struct {
+	Level  string `yaml:"level" json:"level"`   // debug, info, warn or error. CYDIAN_LOG_LEVEL
+	Format string `yaml:"format" json:"format"` // text or json. CYDIAN_LOG_FORMAT
+}
+
+// TracingConfig only applies on startup
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp. CYDIAN_TRACING_EXPORTER
+	Exporter string `yaml:"exporter" json:"exporter"`
+	// Endpoint is the OTLP/HTTP collector, ie: "localhost:4318". CYDIAN_TRACING_ENDPOINT
+	// When empty, the standard OTEL_EXPORTER_OTLP_* variables apply.
+	Endpoint    string  `yaml:"endpoint" json:"endpoint"`
+	Insecure    bool    `yaml:"insecure" json:"insecure"`         // plain HTTP to the collector
+	SampleRatio float64 `yaml:"sample_ratio" json:"sample_ratio"` // of new traces, incoming sampled traces are always kept
+}
+
+// AuditConfig only applies on startup
+type AuditConfig struct {
+	// Sink is memory, file or jetstream. CYDIAN_AUDIT_SINK
+	Sink string `yaml:"sink" json:"sink"`
+	File string `yaml:"file" json:"file"` // the JSON lines file of the file sink. CYDIAN_AUDIT_FILE
+	// Stream is the JetStream stream of the jetstream sink, prefixed with the environment
+	Stream        string   `yaml:"stream" json:"stream"`
+	MaxAge        Duration `yaml:"max_age" json:"max_age"`               // how long the stream keeps entries, 0 keeps them forever
+	MemoryEntries int      `yaml:"memory_entries" json:"memory_entries"` // how many entries the memory sink keeps
+}
+
+// PartyEventsConfig only applies on startup
+type PartyEventsConfig struct {
+	// Stream is the JetStream stream party events are published to, prefixed with the environment.
+	// Events are not published while it is empty. CYDIAN_PARTY_EVENTS_STREAM
+	Stream string   `yaml:"stream" json:"stream"`
+	MaxAge Duration `yaml:"max_age" json:"max_age"` // how long the stream keeps events, 0 keeps them forever
+}
+
+// ServersConfig is reloadable
+type ServersConfig struct {
+	HealthCheckInterval Duration `yaml:"health_check_interval" json:"health_check_interval"`
+	HealthCheckTimeout  Duration `yaml:"health_check_timeout" json:"health_check_timeout"` // per server
+}
+
+// PartiesConfig is reloadable
+type PartiesConfig struct {
+	InviteExpiry      Duration `yaml:"invite_expiry" json:"invite_expiry"`
+	JoinRequestExpiry Duration `yaml:"join_request_expiry" json:"join_request_expiry"`
+	DisconnectGrace   Duration `yaml:"disconnect_grace" json:"disconnect_grace"`
+	KickCooldown      Duration `yaml:"kick_cooldown" json:"kick_cooldown"` // how long kicked players can't rejoin, 0 disables it
+	// RoleDisconnectGrace overrides DisconnectGrace by party role: leader, moderator or member
+	RoleDisconnectGrace map[string]Duration `yaml:"role_disconnect_grace" json:"role_disconnect_grace"`
+	// LeaderOfflineTransfer hands leadership to an online player as soon as the leader disconnects.
+	// With RestoreLeader, they get it back if they reconnect before being removed.
+	LeaderOfflineTransfer bool `yaml:"leader_offline_transfer" json:"leader_offline_transfer"`
+	RestoreLeader         bool `yaml:"restore_leader" json:"restore_leader"`
+	// TombstoneRetention is how long disbanded parties are remembered for party.sync. Servers syncing
+	// from an older version get every party instead of the changes.
+	TombstoneRetention Duration `yaml:"tombstone_retention" json:"tombstone_retention"`
+	// ChatRateLimit is how many party chat messages a player may send per ChatRateWindow
+	ChatRateLimit  int      `yaml:"chat_rate_limit" json:"chat_rate_limit"`
+	ChatRateWindow Duration `yaml:"chat_rate_window" json:"chat_rate_window"`
+	// MaxSize caps a party's players plus its outstanding invites, unless the leader's rank has its own cap
+	MaxSize      int            `yaml:"max_size" json:"max_size"`
+	RankMaxSizes map[string]int `yaml:"rank_max_sizes" json:"rank_max_sizes"` // by leader rank, ie: {"vip": 12}
+}
+
+// InstancesConfig is reloadable
+type InstancesConfig struct {
+	PrivateStartupTimeout Duration `yaml:"private_startup_timeout" json:"private_startup_timeout"`
+	PrivateEmptyTimeout   Duration `yaml:"private_empty_timeout" json:"private_empty_timeout"`
+	ScheduleFile          string   `yaml:"schedule_file" json:"schedule_file"` // CYDIAN_SCHEDULE_FILE
+}
+
+type MaintenanceConfig struct {
+	Environments []string `yaml:"environments" json:"environments"` // CYDIAN_MAINTENANCE_ENVIRONMENTS, comma separated
+}
+
+// Duration is a time.Duration written as a string in the config file, ie: "30s" or "5m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// D returns the value as a time.Duration
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+// Default returns the built-in configuration, used for anything the file and environment don't set
+func Default() Config {
+	return Config{
+		Metrics: MetricsConfig{Address: ":8081"},
+		Logging: LoggingConfig{Level: "info", Format: "text"},
+		Tracing: TracingConfig{Exporter: "none", SampleRatio: 1},
+		Audit:   AuditConfig{Sink: "memory", File: "audit.log", Stream: "CYDIAN_AUDIT", MemoryEntries: 10000},
+		Nats: NatsConfig{
+			ReconnectWait: Duration(2 * time.Second),
+			MaxReconnects: -1,
+		},
+		Servers: ServersConfig{
+			HealthCheckInterval: Duration(30 * time.Second),
+			HealthCheckTimeout:  Duration(5 * time.Second),
+		},
+		Parties: PartiesConfig{
+			InviteExpiry:       Duration(60 * time.Second),
+			JoinRequestExpiry:  Duration(60 * time.Second),
+			DisconnectGrace:    Duration(5 * time.Minute),
+			TombstoneRetention: Duration(10 * time.Minute),
+			MaxSize:            8,
+			ChatRateLimit:      5,
+			ChatRateWindow:     Duration(5 * time.Second),
+		},
+		Instances: InstancesConfig{
+			PrivateStartupTimeout: Duration(2 * time.Minute),
+			PrivateEmptyTimeout:   Duration(5 * time.Minute),
+		},
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Metrics.Address == "" {
+		errs = append(errs, errors.New("metrics.address must be set"))
+	}
+	if len(c.Nats.Servers) == 0 && (c.Nats.Hostname == "" || c.Nats.Port == "") {
+		errs = append(errs, errors.New("nats.servers or nats.hostname and nats.port must be set"))
+	}
+	if c.Nats.CredsFile != "" && c.Nats.NkeySeedFile != "" {
+		errs = append(errs, errors.New("nats.creds_file and nats.nkey_seed_file are mutually exclusive"))
+	}
+	if (c.Nats.TLS.CertFile == "") != (c.Nats.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("nats.tls.cert_file and nats.tls.key_file must be set together"))
+	}
+	if c.Nats.ReconnectWait <= 0 {
+		errs = append(errs, errors.New("nats.reconnect_wait must be positive"))
+	}
+	if c.Servers.HealthCheckInterval <= 0 {
+		errs = append(errs, errors.New("servers.health_check_interval must be positive"))
+	}
+	if c.Servers.HealthCheckTimeout <= 0 {
+		errs = append(errs, errors.New("servers.health_check_timeout must be positive"))
+	}
+	if c.Parties.InviteExpiry <= 0 {
+		errs = append(errs, errors.New("parties.invite_expiry must be positive"))
+	}
+	if c.Parties.JoinRequestExpiry <= 0 {
+		errs = append(errs, errors.New("parties.join_request_expiry must be positive"))
+	}
+	if c.Parties.DisconnectGrace < 0 {
+		errs = append(errs, errors.New("parties.disconnect_grace cannot be negative"))
+	}
+	for role, grace := range c.Parties.RoleDisconnectGrace {
+		switch role {
+		case "leader", "moderator", "member":
+		default:
+			errs = append(errs, fmt.Errorf("parties.role_disconnect_grace.%s: role must be leader, moderator or member", role))
+		}
+		if grace < 0 {
+			errs = append(errs, fmt.Errorf("parties.role_disconnect_grace.%s cannot be negative", role))
+		}
+	}
+	if c.Parties.KickCooldown < 0 {
+		errs = append(errs, errors.New("parties.kick_cooldown cannot be negative"))
+	}
+	if c.Parties.TombstoneRetention <= 0 {
+		errs = append(errs, errors.New("parties.tombstone_retention must be positive"))
+	}
+	if c.Parties.ChatRateLimit <= 0 || c.Parties.ChatRateWindow <= 0 {
+		errs = append(errs, errors.New("parties.chat_rate_limit and parties.chat_rate_window must be positive"))
+	}
+	if c.Parties.MaxSize < 2 {
+		errs = append(errs, errors.New("parties.max_size must be at least 2"))
+	}
+	for rank, size := range c.Parties.RankMaxSizes {
+		if size < 2 {
+			errs = append(errs, fmt.Errorf("parties.rank_max_sizes.%s must be at least 2", rank))
+		}
+	}
+	if c.Instances.PrivateStartupTimeout <= 0 {
+		errs = append(errs, errors.New("instances.private_startup_timeout must be positive"))
+	}
+	if c.Instances.PrivateEmptyTimeout <= 0 {
+		errs = append(errs, errors.New("instances.private_empty_timeout must be positive"))
+	}
+	if _, err := logging.ParseLevel(c.Logging.Level); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level: %w", err))
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		errs = append(errs, errors.New("logging.format must be text or json"))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, errors.New("tracing.exporter must be none, stdout or otlp"))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+	switch c.Audit.Sink {
+	case "memory":
+		if c.Audit.MemoryEntries <= 0 {
+			errs = append(errs, errors.New("audit.memory_entries must be positive"))
+		}
+	case "file":
+		if c.Audit.File == "" {
+			errs = append(errs, errors.New("audit.file must be set for the file sink"))
+		}
+	case "jetstream":
+		if c.Audit.Stream == "" {
+			errs = append(errs, errors.New("audit.stream must be set for the jetstream sink"))
+		}
+	default:
+		errs = append(errs, errors.New("audit.sink must be memory, file or jetstream"))
+	}
+	if c.Audit.MaxAge < 0 {
+		errs = append(errs, errors.New("audit.max_age cannot be negative"))
+	}
+	if c.PartyEvents.MaxAge < 0 {
+		errs = append(errs, errors.New("party_events.max_age cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	current   atomic.Pointer[Config]
+	path      string
+	listeners []func(*Config)
+	reloadMu  sync.Mutex
+	modTime   time.Time
+)
+
+// Get returns the active configuration. It must not be modified.
+func Get() *Config {
+	if c := current.Load(); c != nil {
+		return c
+	}
+	c := Default()
+	return &c
+}
+
+// Load reads, validates and activates the configuration. It is called once on startup.
+func Load() (*Config, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	path = os.Getenv("CYDIAN_CONFIG")
+	if path == "" {
+		path = "cydian.yml"
+	}
+	cfg, err := read()
+	if err != nil {
+		return nil, err
+	}
+	current.Store(cfg)
+	return cfg, nil
+}
+
+// OnReload registers a function that is called with the new configuration after every successful reload
+func OnReload(fn func(*Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Reload re-reads the configuration. An invalid file leaves the active configuration untouched.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, err := read()
+	if err != nil {
+		return err
+	}
+
+	old := Get()
+	if cfg.Metrics != old.Metrics || !reflect.DeepEqual(cfg.Nats, old.Nats) ||
+		!slices.Equal(cfg.Maintenance.Environments, old.Maintenance.Environments) ||
+		cfg.Logging.Format != old.Logging.Format || cfg.Tracing != old.Tracing || cfg.Audit != old.Audit || cfg.PartyEvents != old.PartyEvents {
+		logger.Warn("metrics, nats, maintenance, logging.format, tracing, audit and party_events settings only apply after a restart")
+		cfg.Metrics = old.Metrics
+		cfg.Nats = old.Nats
+		cfg.Maintenance = old.Maintenance
+		cfg.Logging.Format = old.Logging.Format
+		cfg.Tracing = old.Tracing
+		cfg.Audit = old.Audit
+		cfg.PartyEvents = old.PartyEvents
+	}
+
+	current.Store(cfg)
+	for _, fn := range listeners {
+		fn(cfg)
+	}
+	logger.Info("reloaded configuration", "path", path)
+	return nil
+}
+
+// Watch reloads the configuration whenever the file's modification time changes
+func Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		reloadMu.Lock()
+		changed := !info.ModTime().Equal(modTime)
+		reloadMu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := Reload(); err != nil {
+			logger.Error("failed to reload configuration", "path", path, "err", err)
+			// don't log the same error every poll, wait for the file to change again
+			reloadMu.Lock()
+			modTime = info.ModTime()
+			reloadMu.Unlock()
+		}
+	}
+}
+
+// read must be called with reloadMu held
+func read() (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+	case errors.Is(err, os.ErrNotExist):
+		logger.Info("no config file, using defaults and environment variables", "path", path)
+	default:
+		return nil, err
+	}
+
+	applyEnv(&cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return &cfg, nil
+}
+
+func applyEnv(cfg *Config) {
+	set := func(dst *string, key string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	set(&cfg.Metrics.Address, "CYDIAN_METRICS_ADDRESS")
+	set(&cfg.Nats.Hostname, "NATS_HOSTNAME")
+	set(&cfg.Nats.Port, "NATS_PORT")
+	set(&cfg.Nats.Username, "NATS_USERNAME")
+	set(&cfg.Nats.Password, "NATS_PASSWORD")
+	set(&cfg.Nats.CredsFile, "NATS_CREDS_FILE")
+	set(&cfg.Nats.NkeySeedFile, "NATS_NKEY_SEED_FILE")
+	set(&cfg.Nats.TLS.CAFile, "NATS_TLS_CA_FILE")
+	set(&cfg.Nats.TLS.CertFile, "NATS_TLS_CERT_FILE")
+	set(&cfg.Nats.TLS.KeyFile, "NATS_TLS_KEY_FILE")
+	if v, ok := os.LookupEnv("NATS_SERVERS"); ok {
+		cfg.Nats.Servers = strings.Split(v, ",")
+	}
+	set(&cfg.Instances.ScheduleFile, "CYDIAN_SCHEDULE_FILE")
+	set(&cfg.Admin.Token, "CYDIAN_ADMIN_TOKEN")
+	set(&cfg.Logging.Level, "CYDIAN_LOG_LEVEL")
+	set(&cfg.Logging.Format, "CYDIAN_LOG_FORMAT")
+	set(&cfg.Tracing.Exporter, "CYDIAN_TRACING_EXPORTER")
+	set(&cfg.Tracing.Endpoint, "CYDIAN_TRACING_ENDPOINT")
+	set(&cfg.Audit.Sink, "CYDIAN_AUDIT_SINK")
+	set(&cfg.Audit.File, "CYDIAN_AUDIT_FILE")
+	set(&cfg.PartyEvents.Stream, "CYDIAN_PARTY_EVENTS_STREAM")
+	if v, ok := os.LookupEnv("CYDIAN_MAINTENANCE_ENVIRONMENTS"); ok {
+		cfg.Maintenance.Environments = strings.Split(v, ",")
+	}
+}
+
+type ReloadResponse struct {
+	Success bool   `json:"success"`
+	Code    string `json:"code"`    // ie: "ERR_RELOAD_FAILED"
+	Message string `json:"message"` // the validation error, when the reload failed
+}
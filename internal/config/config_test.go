@@ -0,0 +1,204 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/logging"
+)
+
+// envKeys are the variables applyEnv reads, cleared so the machine running the tests can't change them
+var envKeys = []string{
+	"CYDIAN_METRICS_ADDRESS", "NATS_HOSTNAME", "NATS_PORT", "NATS_USERNAME", "NATS_PASSWORD", "NATS_CREDS_FILE",
+	"NATS_NKEY_SEED_FILE", "NATS_TLS_CA_FILE", "NATS_TLS_CERT_FILE", "NATS_TLS_KEY_FILE", "NATS_SERVERS",
+	"CYDIAN_SCHEDULE_FILE", "CYDIAN_ADMIN_TOKEN", "CYDIAN_LOG_LEVEL", "CYDIAN_LOG_FORMAT", "CYDIAN_TRACING_EXPORTER",
+	"CYDIAN_TRACING_ENDPOINT", "CYDIAN_AUDIT_SINK", "CYDIAN_AUDIT_FILE", "CYDIAN_PARTY_EVENTS_STREAM",
+	"CYDIAN_MAINTENANCE_ENVIRONMENTS",
+}
+
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range envKeys {
+		t.Setenv(key, "") // restores the variable after the test
+		os.Unsetenv(key)
+	}
+}
+
+func valid() Config {
+	cfg := Default()
+	cfg.Nats.Hostname = "localhost"
+	cfg.Nats.Port = "4222"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		err    string // a part of the error, empty when the config is valid
+	}{
+		{"defaults with a nats server", func(c *Config) {}, ""},
+		{"nats servers instead of a hostname", func(c *Config) {
+			c.Nats.Hostname, c.Nats.Port = "", ""
+			c.Nats.Servers = []string{"nats://nats-1:4222"}
+		}, ""},
+		{"no nats server", func(c *Config) { c.Nats.Port = "" }, "nats.servers or nats.hostname and nats.port"},
+		{"both nats credentials", func(c *Config) {
+			c.Nats.CredsFile, c.Nats.NkeySeedFile = "a.creds", "a.nk"
+		}, "mutually exclusive"},
+		{"a certificate without its key", func(c *Config) { c.Nats.TLS.CertFile = "cert.pem" }, "must be set together"},
+		{"no invite expiry", func(c *Config) { c.Parties.InviteExpiry = 0 }, "parties.invite_expiry"},
+		{"an unknown role", func(c *Config) {
+			c.Parties.RoleDisconnectGrace = map[string]Duration{"owner": Duration(time.Minute)}
+		}, "role must be leader, moderator or member"},
+		{"a negative role grace", func(c *Config) {
+			c.Parties.RoleDisconnectGrace = map[string]Duration{"leader": Duration(-time.Minute)}
+		}, "role_disconnect_grace.leader cannot be negative"},
+		{"a party of one", func(c *Config) { c.Parties.MaxSize = 1 }, "parties.max_size"},
+		{"a rank party of one", func(c *Config) { c.Parties.RankMaxSizes = map[string]int{"vip": 1} }, "rank_max_sizes.vip"},
+		{"an unknown log level", func(c *Config) { c.Logging.Level = "loud" }, "logging.level"},
+		{"an unknown exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, "tracing.exporter"},
+		{"a sample ratio above 1", func(c *Config) { c.Tracing.SampleRatio = 2 }, "tracing.sample_ratio"},
+		{"a file sink without a file", func(c *Config) {
+			c.Audit.Sink, c.Audit.File = "file", ""
+		}, "audit.file"},
+		{"an unknown sink", func(c *Config) { c.Audit.Sink = "redis" }, "audit.sink"},
+	}
+	for _, test := range tests {
+		cfg := valid()
+		test.modify(&cfg)
+		err := cfg.Validate()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.err)
+		}
+	}
+
+	// every problem is reported at once
+	cfg := valid()
+	cfg.Parties.MaxSize = 1
+	cfg.Logging.Format = "xml"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "max_size") || !strings.Contains(err.Error(), "logging.format") {
+		t.Errorf("got %v, want both errors", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	if err := logging.Setup(io.Discard, "text", "error"); err != nil {
+		t.Fatal(err)
+	}
+	clearEnv(t)
+	file := filepath.Join(t.TempDir(), "cydian.yml")
+	t.Setenv("CYDIAN_CONFIG", file)
+	t.Cleanup(func() {
+		current.Store(nil)
+		listeners = nil
+	})
+	write := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+nats:
+  hostname: nats-1
+  port: "4222"
+logging:
+  level: info
+  format: text
+parties:
+  max_size: 8
+`)
+	if _, err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	var notified *Config
+	OnReload(func(cfg *Config) { notified = cfg })
+
+	tests := []struct {
+		name     string
+		contents string
+		err      bool
+		check    func(c *Config) string // describes what is wrong with the active config, if anything
+	}{
+		{"reloadable settings apply", `
+nats:
+  hostname: nats-1
+  port: "4222"
+logging:
+  level: debug
+parties:
+  max_size: 12
+`, false, func(c *Config) string {
+			if c.Logging.Level != "debug" || c.Parties.MaxSize != 12 {
+				return "the reloadable settings weren't applied"
+			}
+			return ""
+		}},
+		{"restart-only settings are reset", `
+nats:
+  hostname: nats-2
+  port: "4223"
+logging:
+  level: warn
+  format: json
+audit:
+  sink: file
+parties:
+  max_size: 12
+`, false, func(c *Config) string {
+			if c.Nats.Hostname != "nats-1" || c.Nats.Port != "4222" || c.Logging.Format != "text" || c.Audit.Sink != "memory" {
+				return "a restart-only setting changed"
+			}
+			if c.Logging.Level != "warn" {
+				return "the log level wasn't applied next to the restart-only settings"
+			}
+			return ""
+		}},
+		{"an invalid file keeps the active config", `
+nats:
+  hostname: nats-1
+  port: "4222"
+parties:
+  max_size: 1
+`, true, func(c *Config) string {
+			if c.Parties.MaxSize != 12 || c.Logging.Level != "warn" {
+				return "the active config changed"
+			}
+			return ""
+		}},
+		{"an unknown setting keeps the active config", `
+parties:
+  max_sise: 10
+`, true, func(c *Config) string {
+			if c.Parties.MaxSize != 12 {
+				return "the active config changed"
+			}
+			return ""
+		}},
+	}
+	for _, test := range tests {
+		write(test.contents)
+		notified = nil
+		err := Reload()
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want an error: %v", test.name, err, test.err)
+		}
+		if problem := test.check(Get()); problem != "" {
+			t.Errorf("%s: %s", test.name, problem)
+		}
+		if test.err == (notified != nil) {
+			t.Errorf("%s: listeners notified: %v", test.name, notified != nil)
+		} else if notified != nil && notified != Get() {
+			t.Errorf("%s: listeners weren't given the active config", test.name)
+		}
+	}
+}
@@ -14,7 +14,12 @@ import (
 //
 // Unknown or empty defaults to dev_.
 func Prefix() string {
-	val := strings.ToUpper(strings.TrimSpace(os.Getenv("CYTONIC_ENVIRONMENT")))
+	return PrefixFor(os.Getenv("CYTONIC_ENVIRONMENT"))
+}
+
+// PrefixFor returns the prefix of the named environment, using the same mapping as Prefix.
+func PrefixFor(name string) string {
+	val := strings.ToUpper(strings.TrimSpace(name))
 	switch val {
 	case "DEVELOPMENT":
 		return "dev_"
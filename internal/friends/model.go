@@ -23,6 +23,17 @@ type FriendResponse struct {
 	Recipient uuid.UUID `json:"recipient"`
 }
 
+// FriendPendingRequest The json "packet" asking for a player's pending requests
+type FriendPendingRequest struct {
+	Player uuid.UUID `json:"player"`
+}
+
+// FriendPendingResponse The pending requests sent to and by a player
+type FriendPendingResponse struct {
+	Incoming []FriendRequest `json:"incoming"`
+	Outgoing []FriendRequest `json:"outgoing"`
+}
+
 type FriendRequestApiResponse struct {
 	Success bool   `json:"success"`
 	Code    string `json:"code"` //ie: "ALREADY_SENT"
@@ -1,16 +1,22 @@
 package friends
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 	"sync"
 	"time"
 
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var logger = logging.For("friends")
+
 // Registry to store active servers
 type Registry struct {
 	mu sync.Mutex
@@ -25,33 +31,35 @@ func NewRegistry(nc *nats.Conn) *Registry {
 	return &Registry{requests: make(map[uuid.UUID]FriendRequest), nats: nc}
 }
 
-func (r *Registry) AddOrUpdate(req FriendRequest) (bool, bool) {
+func (r *Registry) AddOrUpdate(ctx context.Context, req FriendRequest) (bool, bool) {
+	ctx, span := tracing.Start(ctx, "friends.Registry.AddOrUpdate")
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if r.contains(req) {
-		log.Printf("Friend request registry already contains %v", req)
+		logger.DebugContext(ctx, "friend request already sent", "sender", req.Sender, "recipient", req.Recipient)
 		return false, false
 	}
 
 	if r.containsInverse(req) {
-		log.Printf("Friend request registry contains an inverted request! %v", req)
+		logger.InfoContext(ctx, "friend request matches an inverse request, accepting both", "sender", req.Sender, "recipient", req.Recipient)
 		flipped := FriendRequest{
 			Sender:    req.Recipient,
 			Recipient: req.Sender,
 			Expiry:    req.Expiry,
 		}
-		SendAcceptance(r.nats, flipped)
+		SendAcceptance(ctx, r.nats, flipped)
 		return true, true // successful
 	}
 
 	reqUUID := uuid.New()
 
-	log.Printf("Friend request registry adding %v", reqUUID)
+	logger.InfoContext(ctx, "friend request sent", "request", reqUUID, "sender", req.Sender, "recipient", req.Recipient)
 	go func() {
 		time.AfterFunc(req.Expiry.Sub(time.Now()), func() {
-			log.Printf("Friend request registry expired %v", req)
-			r.expireRequest(reqUUID)
+			logger.InfoContext(ctx, "friend request expired", "request", reqUUID)
+			r.expireRequest(ctx, reqUUID)
 		})
 	}()
 
@@ -60,21 +68,26 @@ func (r *Registry) AddOrUpdate(req FriendRequest) (bool, bool) {
 	return true, false
 }
 
-func (r *Registry) AcceptByID(id uuid.UUID) (bool, FriendRequest) {
+func (r *Registry) AcceptByID(ctx context.Context, id uuid.UUID) (bool, FriendRequest) {
+	ctx, span := tracing.Start(ctx, "friends.Registry.AcceptByID", attribute.Stringer("cydian.request_id", id))
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if r.containsKey(id) {
 		req := r.requests[id]
 		delete(r.requests, id)
-		log.Printf("Accepted request: %+v", id)
+		logger.InfoContext(ctx, "friend request accepted", "request", id)
 		return true, req
 	}
-	log.Printf("Attempted to accept invalid request: %+v", id)
+	logger.DebugContext(ctx, "attempted to accept an unknown friend request", "request", id)
 	return false, FriendRequest{}
 }
 
-func (r *Registry) Accept(sender uuid.UUID, recipient uuid.UUID) (bool, FriendRequest) {
+func (r *Registry) Accept(ctx context.Context, sender uuid.UUID, recipient uuid.UUID) (bool, FriendRequest) {
+	ctx, span := tracing.Start(ctx, "friends.Registry.Accept",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.recipient_id", recipient))
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -93,30 +106,35 @@ func (r *Registry) Accept(sender uuid.UUID, recipient uuid.UUID) (bool, FriendRe
 
 	if found {
 		delete(r.requests, id)
-		log.Printf("Accepted request: %+v", id)
+		logger.InfoContext(ctx, "friend request accepted", "request", id)
 		return true, req
 	}
 
-	log.Printf("Attempted to accept invalid request: %+v", id)
+	logger.DebugContext(ctx, "attempted to accept an unknown friend request", "request", id)
 	return false, FriendRequest{}
 }
 
 // DeclineByID  Functionally the same as AcceptByID, but it sends a slightly different message. :)
-func (r *Registry) DeclineByID(id uuid.UUID) (bool, FriendRequest) {
+func (r *Registry) DeclineByID(ctx context.Context, id uuid.UUID) (bool, FriendRequest) {
+	ctx, span := tracing.Start(ctx, "friends.Registry.DeclineByID", attribute.Stringer("cydian.request_id", id))
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if r.containsKey(id) {
 		req := r.requests[id]
 		delete(r.requests, id)
-		log.Printf("DeclineByID request: %+v", id)
+		logger.InfoContext(ctx, "friend request declined", "request", id)
 		return true, req
 	}
-	log.Printf("Attempted to decline invalid request: %+v", id)
+	logger.DebugContext(ctx, "attempted to decline an unknown friend request", "request", id)
 	return false, FriendRequest{}
 }
 
-func (r *Registry) Decline(sender uuid.UUID, recipient uuid.UUID) (bool, FriendRequest) {
+func (r *Registry) Decline(ctx context.Context, sender uuid.UUID, recipient uuid.UUID) (bool, FriendRequest) {
+	ctx, span := tracing.Start(ctx, "friends.Registry.Decline",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.recipient_id", recipient))
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -135,11 +153,11 @@ func (r *Registry) Decline(sender uuid.UUID, recipient uuid.UUID) (bool, FriendR
 
 	if found {
 		delete(r.requests, id)
-		log.Printf("Declined request: %+v", id)
+		logger.InfoContext(ctx, "friend request declined", "request", id)
 		return true, req
 	}
 
-	log.Printf("Attempted to decline invalid request: %+v", id)
+	logger.DebugContext(ctx, "attempted to decline an unknown friend request", "request", id)
 	return false, FriendRequest{}
 }
 
@@ -154,13 +172,35 @@ func (r *Registry) GetAll() []FriendRequest {
 	return servers
 }
 
+// Pending returns the requests sent to and by the player
+func (r *Registry) Pending(player uuid.UUID) FriendPendingResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := FriendPendingResponse{
+		Incoming: make([]FriendRequest, 0),
+		Outgoing: make([]FriendRequest, 0),
+	}
+	for _, request := range r.requests {
+		if request.Recipient == player {
+			pending.Incoming = append(pending.Incoming, request)
+		}
+		if request.Sender == player {
+			pending.Outgoing = append(pending.Outgoing, request)
+		}
+	}
+	return pending
+}
+
 func (r *Registry) Get(id uuid.UUID) FriendRequest {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	return r.requests[id]
 }
 
-func (r *Registry) expireRequest(requestUUID uuid.UUID) {
+func (r *Registry) expireRequest(ctx context.Context, requestUUID uuid.UUID) {
+	ctx, span := tracing.Start(ctx, "friends.Registry.expireRequest",
+		attribute.Stringer("cydian.request_id", requestUUID))
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	request := r.requests[requestUUID]
@@ -169,19 +209,18 @@ func (r *Registry) expireRequest(requestUUID uuid.UUID) {
 	const subject = "friends.expire.notify"
 	data, err := json.Marshal(request)
 	if err != nil {
-		log.Printf("Error marshalling request: %v", err)
+		logger.ErrorContext(ctx, "failed to marshal friend request expiry", "request", requestUUID, "err", err)
 		return
 	}
-	err1 := r.nats.Publish(env.EnsurePrefixed(subject), data)
+	err1 := utils.Publish(ctx, r.nats, env.EnsurePrefixed(subject), data)
 	if err1 != nil {
-		log.Printf("Error publishing request expiry: %v", err1)
+		logger.ErrorContext(ctx, "failed to publish friend request expiry", "request", requestUUID, "err", err1)
 		return
 	}
 }
 
 func (r *Registry) contains(request FriendRequest) bool {
 	for _, friendRequest := range r.requests {
-		log.Printf("Friend request registry contains %v", friendRequest)
 		if request.Sender == friendRequest.Sender && request.Recipient == friendRequest.Recipient {
 			return true
 		}
@@ -207,17 +246,17 @@ func (r *Registry) containsKey(id uuid.UUID) bool {
 	return false
 }
 
-func SendAcceptance(nc *nats.Conn, req FriendRequest) {
+func SendAcceptance(ctx context.Context, nc *nats.Conn, req FriendRequest) {
 
 	const subject = "friends.accept.notify"
 	marshal, errr := json.Marshal(req)
 	if errr != nil {
-		log.Printf("Error marshalling friend request: %v", errr)
+		logger.ErrorContext(ctx, "failed to marshal friend request", "err", errr)
 		return
 	}
-	err := nc.Publish(env.EnsurePrefixed(subject), marshal)
+	err := utils.Publish(ctx, nc, env.EnsurePrefixed(subject), marshal)
 	if err != nil {
-		log.Printf("Error publishing friends acceptance message: %v", err)
+		logger.ErrorContext(ctx, "failed to publish friend acceptance", "err", err)
 		return
 	}
 }
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/nats-io/nats.go"
+)
+
+func RegisterAdmin(nc *nats.Conn) {
+	reloadHandler(nc)
+}
+
+// reloadHandler re-reads the configuration file, replying with ERR_RELOAD_FAILED and the validation error if it is invalid
+func reloadHandler(nc *nats.Conn) {
+	const subject = "cydian.admin.reload"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
+		response := config.ReloadResponse{Success: true, Message: "SUCCESS"}
+		if err := config.Reload(); err != nil {
+			logger.ErrorContext(ctx, "failed to reload configuration", "err", err)
+			response = config.ReloadResponse{Success: false, Code: "ERR_RELOAD_FAILED", Message: err.Error()}
+		}
+		ack, err := json.Marshal(response)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to marshal reload response", "err", err)
+			return
+		}
+		if err := respond(ctx, msg, ack); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	})))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for configuration reloads", "subject", subject)
+}
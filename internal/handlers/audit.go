@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/nats-io/nats.go"
+)
+
+func RegisterAudit(nc *nats.Conn) {
+	auditQueryHandler(nc)
+}
+
+// auditQueryHandler replies with the audit entries matching the player, party and time range in the request
+func auditQueryHandler(nc *nats.Conn) {
+	const subject = "audit.query"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
+		var query audit.Query
+		if err := json.Unmarshal(msg.Data, &query); err != nil {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			ack, _ := json.Marshal(audit.QueryResponse{Success: false, Message: "INVALID_MESSAGE_FORMAT"})
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
+			}
+			return
+		}
+
+		// a jetstream sink replays the stream, don't block the subscription
+		done := detach(ctx)
+		go func() {
+			defer done()
+			response := audit.QueryResponse{Success: true, Message: "SUCCESS"}
+			entries, err := audit.Search(query)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to query the audit trail", "err", err)
+				response = audit.QueryResponse{Success: false, Message: "ERR_AUDIT_UNAVAILABLE"}
+			}
+			response.Entries = entries
+			ack, err := json.Marshal(response)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to marshal audit entries", "err", err)
+				return
+			}
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
+			}
+		}()
+	})))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for audit queries", "subject", subject)
+}
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 
 	"github.com/CytonicMC/Cydian/internal/env"
 	"github.com/CytonicMC/Cydian/internal/friends"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/utils"
 	"github.com/nats-io/nats.go"
 )
 
@@ -15,19 +17,20 @@ func RegisterFriends(nc *nats.Conn, registry *friends.Registry) {
 	acceptHandler(nc, registry)
 	declineHandler(nc, registry)
 	requestHandler(nc, registry)
+	pendingHandler(nc, registry)
 }
 
 func acceptHandlerId(nc *nats.Conn, registry *friends.Registry) {
 	const subject = "friends.accept.by_id"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet friends.FriendResponseId
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			return
 		}
 
-		success, req := registry.AcceptByID(packet.ID)
+		success, req := registry.AcceptByID(ctx, packet.ID)
 
 		// Add or update the server in the registry
 		if success {
@@ -36,38 +39,38 @@ func acceptHandlerId(nc *nats.Conn, registry *friends.Registry) {
 				Code:    "SUCCESS",
 				Message: "Request successfully accepted",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
-			friends.SendAcceptance(nc, req)
+			friends.SendAcceptance(ctx, nc, req)
 		} else {
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
 				Success: false,
 				Code:    "NOT_FOUND",
 				Message: "No valid request to accept.",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 		}
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for friend acceptances on subject '%s'", subject)
+	logger.Info("listening for friend acceptances", "subject", subject)
 }
 
 func declineHandlerId(nc *nats.Conn, registry *friends.Registry) {
 	const subject = "friends.decline.by_id"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet friends.FriendResponseId
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			return
 		}
 
-		success, req := registry.DeclineByID(packet.ID)
+		success, req := registry.DeclineByID(ctx, packet.ID)
 		// Add or update the server in the registry
 		if success {
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
@@ -75,38 +78,38 @@ func declineHandlerId(nc *nats.Conn, registry *friends.Registry) {
 				Code:    "SUCCESS",
 				Message: "Request successfully declined",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
-			sendDeclination(nc, req)
+			sendDeclination(ctx, nc, req)
 		} else {
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
 				Success: false,
 				Code:    "NOT_FOUND",
 				Message: "No valid request to decline.",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 		}
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for friend declinations on subject '%s'", subject)
+	logger.Info("listening for friend declinations", "subject", subject)
 }
 
 func acceptHandler(nc *nats.Conn, registry *friends.Registry) {
 	const subject = "friends.accept"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet friends.FriendResponse
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			return
 		}
 
-		success, req := registry.Accept(packet.Sender, packet.Recipient)
+		success, req := registry.Accept(ctx, packet.Sender, packet.Recipient)
 
 		// Add or update the server in the registry
 		if success {
@@ -115,38 +118,38 @@ func acceptHandler(nc *nats.Conn, registry *friends.Registry) {
 				Code:    "SUCCESS",
 				Message: "Request successfully accepted",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
-			friends.SendAcceptance(nc, req)
+			friends.SendAcceptance(ctx, nc, req)
 		} else {
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
 				Success: false,
 				Code:    "NOT_FOUND",
 				Message: "No valid request to accept.",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 		}
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for friend acceptances on subject '%s'", subject)
+	logger.Info("listening for friend acceptances", "subject", subject)
 }
 
 func declineHandler(nc *nats.Conn, registry *friends.Registry) {
 	const subject = "friends.decline"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet friends.FriendResponse
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			return
 		}
 
-		success, req := registry.Decline(packet.Sender, packet.Recipient)
+		success, req := registry.Decline(ctx, packet.Sender, packet.Recipient)
 		// Add or update the server in the registry
 		if success {
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
@@ -154,38 +157,63 @@ func declineHandler(nc *nats.Conn, registry *friends.Registry) {
 				Code:    "SUCCESS",
 				Message: "Request successfully declined",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
-			sendDeclination(nc, req)
+			sendDeclination(ctx, nc, req)
 		} else {
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
 				Success: false,
 				Code:    "NOT_FOUND",
 				Message: "No valid request to decline.",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 		}
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for friend declinations on subject '%s'", subject)
+	logger.Info("listening for friend declinations", "subject", subject)
 }
 
-func sendDeclination(nc *nats.Conn, req friends.FriendRequest) {
+func pendingHandler(nc *nats.Conn, registry *friends.Registry) {
+	const subject = "friends.pending"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet friends.FriendPendingRequest
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			return
+		}
+
+		ack, err := json.Marshal(registry.Pending(packet.Player))
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to marshal pending friend requests", "err", err)
+			return
+		}
+		if err := respond(ctx, msg, ack); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for pending friend request lookups", "subject", subject)
+}
+
+func sendDeclination(ctx context.Context, nc *nats.Conn, req friends.FriendRequest) {
 
 	const subject = "friends.decline.notify"
 	marshal, errr := json.Marshal(req)
 	if errr != nil {
-		log.Printf("Error marshalling friend request: %v", errr)
+		logger.ErrorContext(ctx, "failed to marshal friend request", "err", errr)
 		return
 	}
-	err := nc.Publish(env.EnsurePrefixed(subject), marshal)
+	err := utils.Publish(ctx, nc, env.EnsurePrefixed(subject), marshal)
 	if err != nil {
-		log.Printf("Error publishing friends declination message: %v", err)
+		logger.ErrorContext(ctx, "failed to publish friend declination", "err", err)
 		return
 	}
 }
@@ -193,50 +221,48 @@ func sendDeclination(nc *nats.Conn, req friends.FriendRequest) {
 func requestHandler(nc *nats.Conn, req *friends.Registry) {
 	const subject = "friends.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 
 		var packet friends.FriendRequest
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			return
 		}
 
 		// register the request
-		success, dontSend := req.AddOrUpdate(packet)
+		success, dontSend := req.AddOrUpdate(ctx, packet)
 		if !success {
-			log.Printf("ALREADY_SENT")
 
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
 				Success: false,
 				Code:    "ALREADY_SENT",
 				Message: "You have already send a request to this player.",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 		} else {
-			log.Printf("Successfully sent")
 			ack, _ := json.Marshal(friends.FriendRequestApiResponse{
 				Success: true,
 				Code:    "SUCCESS",
 				Message: "Request successfully sent.",
 			})
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 
 			if !dontSend {
-				err1 := nc.Publish(env.EnsurePrefixed("friends.request.notify"), msg.Data)
+				err1 := utils.Publish(ctx, nc, env.EnsurePrefixed("friends.request.notify"), msg.Data)
 				if err1 != nil {
-					log.Printf("Error publishing friends request: %v", err1)
+					logger.ErrorContext(ctx, "failed to publish friend request", "err", err1)
 					return
 				}
 			}
 		}
 
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for friend requests on subject '%s'", subject)
+	logger.Info("listening for friend requests", "subject", subject)
 }
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/health"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/nats-io/nats.go"
+)
+
+func RegisterHealth(nc *nats.Conn) {
+	healthHandler(nc)
+}
+
+// healthHandler replies with the same report as /readyz
+func healthHandler(nc *nats.Conn) {
+	const subject = "cydian.health"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		// the checks may call nomad, keep the subscription responsive
+		done := detach(ctx)
+		go func() {
+			defer done()
+			ack, err := json.Marshal(health.Readiness())
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to marshal health report", "err", err)
+				return
+			}
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
+			}
+		}()
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for health requests", "subject", subject)
+}
@@ -1,83 +1,105 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"time"
 
+	"github.com/CytonicMC/Cydian/internal/audit"
 	"github.com/CytonicMC/Cydian/internal/env"
 	"github.com/CytonicMC/Cydian/internal/instances"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/tracing"
 	"github.com/hashicorp/nomad/api"
 	"github.com/nats-io/nats.go"
 )
 
-func RegisterInstances(nc *nats.Conn) {
-	client, err := api.NewClient(api.DefaultConfig())
-	if err != nil {
-		log.Fatal(err)
-	}
+func RegisterInstances(nc *nats.Conn, client *api.Client, private *instances.PrivateRegistry) {
 	createHandler(nc, client)
+	scaleHandler(nc, client)
 	deleteAllHandler(nc, client)
 	deleteHandler(nc, client)
 	updateHandler(nc, client)
+	privateCreateHandler(nc, private)
+	privateListHandler(nc, private)
+	privateOccupancyHandler(nc, private)
 }
 
 func createHandler(nc *nats.Conn, client *api.Client) {
 	const subject = "servers.create"
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
 		var packet instances.InstanceCreateRequest
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "INVALID_MESSAGE_FORMAT",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
 
-		job, _, errJobs := client.Jobs().Info(packet.InstanceType, nil)
-		if errJobs != nil {
-			log.Printf("Error getting job info: %v", errJobs)
+		count, errMsg := instances.GroupCount(ctx, client, packet.InstanceType)
+		if len(errMsg) == 0 {
+			errMsg = instances.ScaleTo(ctx, client, packet.InstanceType, count+packet.Quantity, "Adding instance(s)")
+		}
+		if len(errMsg) > 0 {
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
-				Message: "JOB_NOT_FOUND",
+				Message: errMsg,
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
 
-		count := 0
+		response, _ := json.Marshal(instances.InstanceResponse{
+			Success: true,
+			Message: "SUCCESS",
+		})
+		errRespond := respond(ctx, msg, response)
+		if errRespond != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", errRespond)
+		}
+	})))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for instance creations", "subject", subject)
+}
 
-		for _, group := range job.TaskGroups {
-			if *group.Name == packet.InstanceType {
-				if group.Count == nil {
-					count = packet.Quantity
-					group.Count = &packet.Quantity // if 0 instances, increase set
-				} else {
-					count = *group.Count + packet.Quantity
-				}
+func scaleHandler(nc *nats.Conn, client *api.Client) {
+	const subject = "servers.scale"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
+		var packet instances.InstanceScaleRequest
+		if err := json.Unmarshal(msg.Data, &packet); err != nil || packet.Count < 0 {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			response, _ := json.Marshal(instances.InstanceResponse{
+				Success: false,
+				Message: "INVALID_MESSAGE_FORMAT",
+			})
+			err := respond(ctx, msg, response)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
+			return
 		}
 
-		_, _, err := client.Jobs().Scale(*job.ID, packet.InstanceType, &count, "Adding instance(s)", false, nil, nil)
-
-		if err != nil {
+		errMsg := instances.ScaleTo(ctx, client, packet.InstanceType, packet.Count, "Scaling instances")
+		if len(errMsg) > 0 {
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
-				Message: "JOB_SCALING_FAILED",
+				Message: errMsg,
 			})
-			log.Printf("Error scaling job: %v", err)
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
@@ -86,147 +108,142 @@ func createHandler(nc *nats.Conn, client *api.Client) {
 			Success: true,
 			Message: "SUCCESS",
 		})
-		errRespond := msg.Respond(response)
+		errRespond := respond(ctx, msg, response)
 		if errRespond != nil {
-			log.Printf("Error sending acknowledgment: %v", err)
+			logger.ErrorContext(ctx, "failed to send reply", "err", errRespond)
 		}
-	})
+	})))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for instance creations on subject '%s'", subject)
+	logger.Info("listening for instance scaling", "subject", subject)
 }
 
 func deleteAllHandler(nc *nats.Conn, client *api.Client) {
 	const subject = "servers.delete.all"
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
 		var packet instances.InstanceDeleteAllRequest
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "INVALID_MESSAGE_FORMAT",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
 
-		job, _, errJobs := client.Jobs().Info(packet.InstanceType, nil)
-		if errJobs != nil {
-			log.Printf("Error getting job info: %v", errJobs)
+		errMsg := instances.ScaleTo(ctx, client, packet.InstanceType, 0, "Removing all instances")
+		if errMsg == "JOB_SCALING_FAILED" {
+			errMsg = "SCALE_TO_ZERO_FAILED"
+		}
+		if len(errMsg) > 0 {
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
-				Message: "JOB_NOT_FOUND",
+				Message: errMsg,
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
-		zero := 0
-		for _, group := range job.TaskGroups {
-			if *group.Name == packet.InstanceType {
-				if group.Count == nil {
-
-					group.Count = &zero
-				} else {
-					*group.Count = 0
-				}
-			}
-		}
-
-		_, _, err := client.Jobs().Scale(*job.ID, packet.InstanceType, &zero, "Removing all instances", false, nil, nil)
-		if err != nil {
-			if err != nil {
-				log.Printf("Error registering job: %v", err)
-				response, _ := json.Marshal(instances.InstanceResponse{
-					Success: false,
-					Message: "SCALE_TO_ZERO_FAILED",
-				})
-				err := msg.Respond(response)
-				if err != nil {
-					log.Printf("Error sending acknowledgment: %v", err)
-				}
-				return
-			}
-		}
 
 		response, _ := json.Marshal(instances.InstanceResponse{
 			Success: true,
 			Message: "SUCCESS",
 		})
-		errRespond := msg.Respond(response)
+		errRespond := respond(ctx, msg, response)
 		if errRespond != nil {
-			log.Printf("Error sending acknowledgment: %v", errRespond)
+			logger.ErrorContext(ctx, "failed to send reply", "err", errRespond)
 		}
-	})
+	})))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for bulk instance deletions on subject '%s'", subject)
+	logger.Info("listening for bulk instance deletions", "subject", subject)
 }
 
 func deleteHandler(nc *nats.Conn, client *api.Client) {
 	const subject = "servers.delete"
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
 		var packet instances.InstanceDeleteRequest
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "INVALID_MESSAGE_FORMAT",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
 
+		result := "SUCCESS"
+		defer func() {
+			audit.Record(ctx, audit.Entry{
+				Actor:  audit.Actor(ctx, audit.ActorSystem),
+				Action: "instances.delete",
+				Target: packet.AllocId,
+				Params: map[string]any{"type": packet.InstanceType},
+				Result: result,
+			})
+		}()
+
+		done := tracing.Nomad(ctx, "allocations.info")
 		alloc, _, err := client.Allocations().Info(packet.AllocId, nil)
+		done(err)
 		if err != nil {
-			log.Printf("Failed to fetch allocation %s: %v", packet.AllocId, err)
+			logger.ErrorContext(ctx, "failed to fetch allocation", "allocation", packet.AllocId, "err", err)
+			result = "ALLOCATION_NOT_FOUND"
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "ALLOCATION_NOT_FOUND",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
 
+		done = tracing.Nomad(ctx, "allocations.stop")
 		_, err = client.Allocations().Stop(alloc, nil)
+		done(err)
 		if err != nil {
-			log.Printf("Failed to stop allocation %s: %v", alloc.ID, err)
+			logger.ErrorContext(ctx, "failed to stop allocation", "allocation", alloc.ID, "err", err)
+			result = "FAILED_TO_STOP_ALLOCATION"
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "FAILED_TO_STOP_ALLOCATION",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		} else {
-			log.Printf("Stopped allocation %s", alloc.ID)
+			logger.InfoContext(ctx, "stopped allocation", "allocation", alloc.ID)
 		}
 
+		done = tracing.Nomad(ctx, "jobs.info")
 		job, _, errJobs := client.Jobs().Info(packet.InstanceType, nil)
+		done(errJobs)
 		if errJobs != nil {
-			log.Printf("Error getting job info: %v", errJobs)
+			logger.ErrorContext(ctx, "failed to get job info", "job", packet.InstanceType, "err", errJobs)
+			result = "JOB_NOT_FOUND"
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "JOB_NOT_FOUND",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
@@ -242,16 +259,19 @@ func deleteHandler(nc *nats.Conn, client *api.Client) {
 			}
 		}
 
+		done = tracing.Nomad(ctx, "jobs.register")
 		_, _, err2 := client.Jobs().Register(job, nil)
+		done(err2)
 		if err2 != nil {
-			log.Printf("Error registering job: %v", err2)
+			logger.ErrorContext(ctx, "failed to register job", "job", packet.InstanceType, "err", err2)
+			result = "JOB_REGISTRATION_FAILED"
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "JOB_REGISTRATION_FAILED",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
@@ -260,45 +280,58 @@ func deleteHandler(nc *nats.Conn, client *api.Client) {
 			Success: true,
 			Message: "SUCCESS",
 		})
-		errRespond := msg.Respond(response)
+		errRespond := respond(ctx, msg, response)
 		if errRespond != nil {
-			log.Printf("Error sending acknowledgment: %v", errRespond)
+			logger.ErrorContext(ctx, "failed to send reply", "err", errRespond)
 		}
-	})
+	})))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for instance deletions on subject '%s'", subject)
+	logger.Info("listening for instance deletions", "subject", subject)
 }
 
 func updateHandler(nc *nats.Conn, client *api.Client) {
 	//todo: graceful server updates
 	const subject = "servers.update"
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
 		var packet instances.InstanceCreateRequest
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "INVALID_MESSAGE_FORMAT",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
 
+		result := "SUCCESS"
+		defer func() {
+			audit.Record(ctx, audit.Entry{
+				Actor:  audit.Actor(ctx, audit.ActorSystem),
+				Action: "instances.update",
+				Target: packet.InstanceType,
+				Result: result,
+			})
+		}()
+
+		done := tracing.Nomad(ctx, "jobs.info")
 		job, _, errJobs := client.Jobs().Info(packet.InstanceType, nil)
+		done(errJobs)
 		if errJobs != nil {
-			log.Printf("Error getting job info: %v", errJobs)
+			logger.ErrorContext(ctx, "failed to get job info", "job", packet.InstanceType, "err", errJobs)
+			result = "JOB_NOT_FOUND"
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "JOB_NOT_FOUND",
 			})
-			err := msg.Respond(response)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
@@ -307,17 +340,20 @@ func updateHandler(nc *nats.Conn, client *api.Client) {
 			"update_trigger": fmt.Sprintf("%d", time.Now().UnixNano()),
 		}
 
+		done = tracing.Nomad(ctx, "jobs.register")
 		_, _, err := client.Jobs().Register(job, nil)
+		done(err)
 
 		if err != nil {
+			result = "JOB_REGISTRATION_FAILED"
 			response, _ := json.Marshal(instances.InstanceResponse{
 				Success: false,
 				Message: "JOB_REGISTRATION_FAILED",
 			})
-			log.Printf("Error scaling job: %v", err)
-			err := msg.Respond(response)
+			logger.ErrorContext(ctx, "failed to update job", "job", packet.InstanceType, "err", err)
+			err := respond(ctx, msg, response)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
@@ -326,13 +362,99 @@ func updateHandler(nc *nats.Conn, client *api.Client) {
 			Success: true,
 			Message: "SUCCESS",
 		})
-		errRespond := msg.Respond(response)
+		errRespond := respond(ctx, msg, response)
 		if errRespond != nil {
-			log.Printf("Error sending acknowledgment: %v", err)
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	})))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for instance updates", "subject", subject)
+}
+
+func privateCreateHandler(nc *nats.Conn, private *instances.PrivateRegistry) {
+	const subject = "instances.private.create"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet instances.PrivateInstanceCreateRequest
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			response, _ := json.Marshal(instances.PrivateInstanceResponse{
+				Success: false,
+				Message: "INVALID_MESSAGE_FORMAT",
+			})
+			err := respond(ctx, msg, response)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
+			}
+			return
+		}
+
+		// waiting for the server to register can take a while, don't block other requests. The request's
+		// span stays open until the reply, and its context isn't cancelled when this handler returns.
+		ctx, done := context.WithoutCancel(ctx), detach(ctx)
+		go func() {
+			defer done()
+			instance, errMsg := private.Create(ctx, packet)
+			var response []byte
+			if len(errMsg) > 0 {
+				response, _ = json.Marshal(instances.PrivateInstanceResponse{
+					Success: false,
+					Message: errMsg,
+				})
+			} else {
+				response, _ = json.Marshal(instances.PrivateInstanceResponse{
+					Success:  true,
+					Message:  "SUCCESS",
+					Instance: instance,
+				})
+			}
+			if err := respond(ctx, msg, response); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
+			}
+		}()
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for private instance creations", "subject", subject)
+}
+
+func privateListHandler(nc *nats.Conn, private *instances.PrivateRegistry) {
+	const subject = "instances.private.list"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		response, err := json.Marshal(instances.PrivateInstanceList{
+			Instances: private.GetAll(),
+		})
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to marshal private instance list", "err", err)
+			return
+		}
+		if err := respond(ctx, msg, response); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for private instance list requests", "subject", subject)
+}
+
+// privateOccupancyHandler receives player counts from private instances, used to tear down empty ones
+func privateOccupancyHandler(nc *nats.Conn, private *instances.PrivateRegistry) {
+	const subject = "instances.private.occupancy"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet instances.PrivateInstanceOccupancy
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			return
+		}
+		if !private.UpdateOccupancy(packet.ID, packet.Players) {
+			logger.WarnContext(ctx, "received occupancy for an unknown private instance", "instance", packet.ID)
 		}
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for instance updates on subject '%s'", subject)
+	logger.Info("listening for private instance occupancy", "subject", subject)
 }
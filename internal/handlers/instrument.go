@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/metrics"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var logger = logging.For("handlers")
+
+// tracedFields are the packet fields recorded on request spans, so a trace can be found by player or party
+var tracedFields = []string{
+	"uuid", "player", "player_id", "sender", "sender_id", "recipient", "recipient_id", "party_id", "partyId",
+}
+
+// instrument counts, times and traces every message handled on the subject. The handler's context carries
+// the request's correlation ID and span, so its logs and the notifications it publishes can be traced back to it.
+// Handlers that reply from a goroutine call detach, so the request is timed until the reply instead.
+func instrument(subject string, handler func(ctx context.Context, msg *nats.Msg)) nats.MsgHandler {
+	requests := metrics.Requests.WithLabelValues(subject)
+	duration := metrics.RequestDuration.WithLabelValues(subject)
+	return func(msg *nats.Msg) {
+		start := time.Now()
+		requests.Inc()
+		ctx := logging.With(logging.FromMsg(context.Background(), msg), "subject", subject)
+		ctx = audit.WithActor(ctx, audit.ActorUnknown)
+		ctx, span := tracing.StartConsumer(ctx, subject, msg, requestAttributes(msg.Data)...)
+		span.SetAttributes(attribute.String("cydian.correlation_id", logging.CorrelationID(ctx)))
+
+		request := &pendingRequest{}
+		request.finish = sync.OnceFunc(func() {
+			span.End()
+			duration.Observe(time.Since(start).Seconds())
+		})
+		handler(context.WithValue(ctx, pendingRequestKey{}, request), msg)
+		if !request.detached.Load() {
+			request.finish()
+		}
+	}
+}
+
+type pendingRequestKey struct{}
+
+// pendingRequest is the part of instrument a detached handler finishes itself
+type pendingRequest struct {
+	detached atomic.Bool
+	finish   func()
+}
+
+// detach tells instrument the handler replies later, from a goroutine. The request's span stays open and
+// its duration keeps running until done is called, which the handler must do once it replied.
+func detach(ctx context.Context) (done func()) {
+	request, ok := ctx.Value(pendingRequestKey{}).(*pendingRequest)
+	if !ok {
+		return func() {}
+	}
+	request.detached.Store(true)
+	return request.finish
+}
+
+// staff records the handler's requests as made by staff tooling. Only wrap admin subjects with it.
+func staff(handler func(ctx context.Context, msg *nats.Msg)) func(ctx context.Context, msg *nats.Msg) {
+	return func(ctx context.Context, msg *nats.Msg) {
+		handler(audit.WithActor(ctx, audit.ActorStaff), msg)
+	}
+}
+
+// requestAttributes picks the player and party ids out of a packet
+func requestAttributes(data []byte) []attribute.KeyValue {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return nil
+	}
+	attrs := make([]attribute.KeyValue, 0, 2)
+	for _, name := range tracedFields {
+		var value string
+		if raw, ok := fields[name]; ok && json.Unmarshal(raw, &value) == nil && value != "" {
+			attrs = append(attrs, attribute.String("cydian."+name, value))
+		}
+	}
+	return attrs
+}
+
+// respond replies to msg, counting the reply by its result code and recording it on the request span.
+// Every reply shares the {success, code, message} shape, where message holds the error code when code is absent.
+// Anything that doesn't look like a code is counted as ERROR, so free text never becomes a metric label.
+func respond(ctx context.Context, msg *nats.Msg, data []byte) error {
+	var result struct {
+		Success *bool  `json:"success"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	code := "OK" // lists and other replies without a result
+	if err := json.Unmarshal(data, &result); err != nil {
+		code = "ERROR"
+	} else if result.Success != nil {
+		switch {
+		case *result.Success:
+			code = "SUCCESS"
+		case result.Code != "":
+			code = result.Code
+		default:
+			code = result.Message
+		}
+		if !isCode(code) {
+			code = "ERROR"
+		}
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("cydian.result_code", code))
+	if code != "OK" && code != "SUCCESS" {
+		span.SetStatus(codes.Error, code)
+	}
+
+	subject := msg.Subject
+	if msg.Sub != nil {
+		subject = msg.Sub.Subject
+	}
+	metrics.Replies.WithLabelValues(strings.TrimPrefix(subject, env.Prefix()), code).Inc()
+	return msg.Respond(data)
+}
+
+// isCode reports whether s looks like a result code, ie: ERR_NOT_LEADER
+func isCode(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/metrics"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMain(m *testing.M) {
+	metrics.InitMetrics()
+	m.Run()
+}
+
+// newTestConn connects to an in-process NATS server
+func newTestConn(t *testing.T) *nats.Conn {
+	t.Helper()
+	if err := logging.Setup(io.Discard, "text", "error"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		nc.Close()
+		s.Shutdown()
+	})
+	return nc
+}
+
+// TestInstrumentMetrics checks every request is counted and timed, and every reply counted by its result code
+func TestInstrumentMetrics(t *testing.T) {
+	nc := newTestConn(t)
+	metrics.Requests.Reset()
+	metrics.RequestDuration.Reset()
+	metrics.Replies.Reset()
+	const subject = "test.instrument"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		if err := respond(ctx, msg, msg.Data); err != nil {
+			t.Error(err)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replies := []string{
+		`{"success": true, "message": "SUCCESS"}`,
+		`{"success": false, "message": "ERR_NOT_LEADER"}`,
+		`{"success": false, "code": "ERR_RELOAD_FAILED", "message": "parties.max_size: must be positive"}`,
+		`{"success": false, "message": "No valid request to accept."}`,
+		`{"parties": []}`,
+	}
+	for _, reply := range replies {
+		if _, err := nc.Request(env.EnsurePrefixed(subject), []byte(reply), time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.Requests.WithLabelValues(subject)); got != float64(len(replies)) {
+		t.Errorf("counted %v requests, want %d", got, len(replies))
+	}
+	for code, want := range map[string]float64{"SUCCESS": 1, "ERR_NOT_LEADER": 1, "ERR_RELOAD_FAILED": 1, "ERROR": 1, "OK": 1} {
+		if got := testutil.ToFloat64(metrics.Replies.WithLabelValues(subject, code)); got != want {
+			t.Errorf("counted %v %s replies, want %v", got, code, want)
+		}
+	}
+	if n := testutil.CollectAndCount(metrics.Replies, "nats_replies_total"); n != 5 {
+		t.Errorf("replies have %d label sets, want 5: free text must not become a label", n)
+	}
+
+	// the duration is observed after the reply is sent, give the last one a moment
+	deadline := time.Now().Add(time.Second)
+	for observed(t, subject) != uint64(len(replies)) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := observed(t, subject); got != uint64(len(replies)) {
+		t.Errorf("timed %d requests, want %d", got, len(replies))
+	}
+}
+
+// observed returns how many durations the registry holds for the subject
+func observed(t *testing.T, subject string) uint64 {
+	count, _ := durations(t, subject)
+	return count
+}
+
+// durations returns how many durations the registry holds for the subject, and their sum in seconds
+func durations(t *testing.T, subject string) (uint64, float64) {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "nats_request_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "subject" && label.GetValue() == subject {
+					return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	return 0, 0
+}
+
+// TestInstrumentDetached checks a handler replying from a goroutine is timed until its reply
+func TestInstrumentDetached(t *testing.T) {
+	nc := newTestConn(t)
+	metrics.RequestDuration.Reset()
+	metrics.Replies.Reset()
+	const subject = "test.instrument.detached"
+	const delay = 50 * time.Millisecond
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		done := detach(ctx)
+		go func() {
+			defer done()
+			time.Sleep(delay)
+			if err := respond(ctx, msg, []byte(`{"success": false, "message": "ERR_TIMEOUT"}`)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Request(env.EnsurePrefixed(subject), nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for observed(t, subject) != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if count, sum := durations(t, subject); count != 1 || sum < delay.Seconds() {
+		t.Errorf("timed %d requests taking %vs, want 1 taking at least %vs", count, sum, delay.Seconds())
+	}
+	if got := testutil.ToFloat64(metrics.Replies.WithLabelValues(subject, "ERR_TIMEOUT")); got != 1 {
+		t.Errorf("counted %v ERR_TIMEOUT replies, want 1", got)
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/network"
+	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/CytonicMC/Cydian/internal/schedule"
+	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+func RegisterNetwork(nc *nats.Conn, maintenance *network.Maintenance, registry *servers.Registry, scheduler *schedule.Scheduler, partyRegistry *parties.PartyRegistry) {
+	maintenanceSetHandler(nc, maintenance)
+	maintenanceGetHandler(nc, maintenance)
+	selectHandler(nc, maintenance, registry, scheduler, partyRegistry)
+}
+
+func maintenanceSetHandler(nc *nats.Conn, maintenance *network.Maintenance) {
+	const subject = "network.maintenance.set"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
+		var packet network.MaintenanceSetRequest
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			sendMaintenanceReply(ctx, msg, network.MaintenanceResponse{
+				Success: false,
+				Message: "INVALID_MESSAGE_FORMAT",
+			})
+			return
+		}
+
+		// scaling may take a moment, don't block the subscription. The request's span stays open until
+		// the reply, and its context isn't cancelled when this handler returns.
+		ctx, done := context.WithoutCancel(ctx), detach(ctx)
+		go func() {
+			defer done()
+			state, errMsg := maintenance.Set(ctx, packet)
+			sendMaintenanceReply(ctx, msg, network.MaintenanceResponse{
+				Success: len(errMsg) == 0,
+				Message: errMsg,
+				State:   state,
+			})
+		}()
+	})))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for maintenance changes", "subject", subject)
+}
+
+func maintenanceGetHandler(nc *nats.Conn, maintenance *network.Maintenance) {
+	const subject = "network.maintenance.get"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		sendMaintenanceReply(ctx, msg, network.MaintenanceResponse{
+			Success: true,
+			State:   maintenance.Get(),
+		})
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for maintenance requests", "subject", subject)
+}
+
+// selectHandler picks a server of the requested type for a player, refusing non-staff during maintenance.
+// Private instances are only picked for the party and players they were created for.
+func selectHandler(nc *nats.Conn, maintenance *network.Maintenance, registry *servers.Registry, scheduler *schedule.Scheduler, partyRegistry *parties.PartyRegistry) {
+	const subject = "servers.select"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet network.ServerSelectRequest
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			sendSelectReply(ctx, msg, network.ServerSelectResponse{
+				Success: false,
+				Message: "INVALID_MESSAGE_FORMAT",
+			})
+			return
+		}
+
+		if ok, errMsg := maintenance.Admit(packet.Staff); !ok {
+			sendSelectReply(ctx, msg, network.ServerSelectResponse{
+				Success: false,
+				Message: errMsg,
+			})
+			return
+		}
+		if !packet.Staff && scheduler.InMaintenance(packet.Type) {
+			sendSelectReply(ctx, msg, network.ServerSelectResponse{
+				Success: false,
+				Message: "ERR_TYPE_MAINTENANCE",
+			})
+			return
+		}
+
+		var party *uuid.UUID
+		if id, ok := partyRegistry.PlayerPartyID(parties.UUID(packet.PlayerID)); ok {
+			partyID := uuid.UUID(id)
+			party = &partyID
+		}
+		server, ok := registry.Select(packet.Type, packet.PlayerID, party)
+		if !ok {
+			sendSelectReply(ctx, msg, network.ServerSelectResponse{
+				Success: false,
+				Message: "ERR_NO_SERVER_AVAILABLE",
+			})
+			return
+		}
+		sendSelectReply(ctx, msg, network.ServerSelectResponse{
+			Success: true,
+			Message: "SUCCESS",
+			Server:  server,
+		})
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for server selections", "subject", subject)
+}
+
+func sendMaintenanceReply(ctx context.Context, msg *nats.Msg, data network.MaintenanceResponse) {
+	ack, err := json.Marshal(data)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal maintenance response", "err", err)
+		return
+	}
+	if err := respond(ctx, msg, ack); err != nil {
+		logger.ErrorContext(ctx, "failed to send reply", "err", err)
+	}
+}
+
+func sendSelectReply(ctx context.Context, msg *nats.Msg, data network.ServerSelectResponse) {
+	ack, err := json.Marshal(data)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal server select response", "err", err)
+		return
+	}
+	if err := respond(ctx, msg, ack); err != nil {
+		logger.ErrorContext(ctx, "failed to send reply", "err", err)
+	}
+}
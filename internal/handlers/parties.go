@@ -1,54 +1,82 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 	"strings"
 
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
 	"github.com/CytonicMC/Cydian/internal/parties"
 	"github.com/nats-io/nats.go"
 )
 
 func RegisterParties(nc *nats.Conn, registry *parties.PartyRegistry) {
 	disbandHandler(nc, registry)
+	forceDisbandHandler(nc, registry)
 	joinHandler(nc, registry)
 	leaveHandler(nc, registry)
 	promoteHandler(nc, registry)
 	demoteHandler(nc, registry)
 	transferHandler(nc, registry)
 	kickHandler(nc, registry)
+	banHandler(nc, registry)
+	unbanHandler(nc, registry)
+	muteMemberHandler(nc, registry)
 	stateHandler(nc, registry)
 	fetchHandler(nc, registry)
+	syncHandler(nc, registry)
 	yoinkHandler(nc, registry)
+	listingHandler(nc, registry)
+	successionHandler(nc, registry)
+	finderHandler(nc, registry)
 }
 
 func disbandHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.disband.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyOnePlayerPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyOnePlayerPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyOnePlayerPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, reason := registry.Disband(packet.PartyID, packet.PlayerID)
-		reply(msg, success, reason)
-	})
+		success, reason := registry.Disband(ctx, packet.PartyID, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party disbands", "subject", subject)
+}
+
+func forceDisbandHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.disband.force.request"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyOnePlayerPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyOnePlayerPacket message format", "data", string(msg.Data))
+			return
+		}
+
+		success, reason := registry.ForceDisband(ctx, packet.PartyID)
+		reply(ctx, msg, success, reason)
+	})))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party disbands on subject '%s'", subject)
+	logger.Info("listening for forced party disbands", "subject", subject)
 }
 
 func joinHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.join.request.*" // allow for bypass using wildcard
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyOnePlayerPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyOnePlayerPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyOnePlayerPacket message format", "data", string(msg.Data))
 			return
 		}
 		party := registry.GetParty(packet.PartyID)
@@ -57,143 +85,203 @@ func joinHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 				Success: false,
 				Message: "INVALID_PARTY",
 			})
-			err := msg.Respond(ack)
+			err := respond(ctx, msg, ack)
 			if err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 			return
 		}
-		hasBypassed := strings.Contains(msg.Subject, "bypass")
-		success, reason := registry.JoinParty(party.ID, packet.PlayerID, hasBypassed)
-		reply(msg, success, reason)
-	})
+		source := parties.JoinOpen
+		if strings.Contains(msg.Subject, "bypass") {
+			source = parties.JoinBypass
+		}
+		success, reason := registry.JoinParty(ctx, party.ID, packet.PlayerID, source)
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party join requests on subject '%s'", subject)
+	logger.Info("listening for party join requests", "subject", subject)
 }
 
 func leaveHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.leave.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyLeaveRequestPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyLeaveRequestPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyLeaveRequestPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, reason := registry.LeaveParty(packet.PlayerID)
-		reply(msg, success, reason)
-	})
+		success, reason := registry.LeaveParty(ctx, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party invite acceptances on subject '%s'", subject)
+	logger.Info("listening for party invite acceptances", "subject", subject)
 }
 
 func promoteHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.promote.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyTwoPlayerPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyTwoPlayerPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyTwoPlayerPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, reason := registry.Promote(packet.SenderID, packet.PartyID, packet.PlayerID)
-		reply(msg, success, reason)
-	})
+		success, reason := registry.Promote(ctx, packet.SenderID, packet.PartyID, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party promotions on subject '%s'", subject)
+	logger.Info("listening for party promotions", "subject", subject)
 }
 
 func demoteHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.demote.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyTwoPlayerPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyTwoPlayerPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyTwoPlayerPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, reason := registry.Demote(packet.SenderID, packet.PartyID, packet.PlayerID)
-		reply(msg, success, reason)
-	})
+		success, reason := registry.Demote(ctx, packet.SenderID, packet.PartyID, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party promotions on subject '%s'", subject)
+	logger.Info("listening for party promotions", "subject", subject)
+}
+
+func banHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.ban.request"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyTwoPlayerPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyTwoPlayerPacket message format", "data", string(msg.Data))
+			return
+		}
+
+		success, reason := registry.Ban(ctx, packet.SenderID, packet.PartyID, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party bans", "subject", subject)
+}
+
+func unbanHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.unban.request"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyTwoPlayerPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyTwoPlayerPacket message format", "data", string(msg.Data))
+			return
+		}
+
+		success, reason := registry.Unban(ctx, packet.SenderID, packet.PartyID, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party unbans", "subject", subject)
+}
+
+func muteMemberHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.mute_member.request"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyMemberMutePacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyMemberMutePacket message format", "data", string(msg.Data))
+			return
+		}
+
+		success, reason := registry.MuteMember(ctx, packet.SenderID, packet.PartyID, packet.PlayerID, packet.State)
+		reply(ctx, msg, success, reason)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party member mutes", "subject", subject)
 }
 
 func transferHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.transfer.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyTwoPlayerPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyTransferPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyTransferPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, reason := registry.Transfer(packet.SenderID, packet.PartyID, packet.PlayerID)
-		reply(msg, success, reason)
-	})
+		success, reason := registry.Transfer(ctx, packet.SenderID, packet.PartyID, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party transfers on subject '%s'", subject)
+	logger.Info("listening for party transfers", "subject", subject)
 }
 
 func yoinkHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.yoink.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyOnePlayerPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyOnePlayerPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyOnePlayerPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, reason := registry.Yoink(packet.PlayerID, packet.PartyID)
-		reply(msg, success, reason)
-	})
+		success, reason := registry.Yoink(ctx, packet.PlayerID, packet.PartyID)
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party yoinks on subject '%s'", subject)
+	logger.Info("listening for party yoinks", "subject", subject)
 }
 
 func kickHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.kick.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyTwoPlayerPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyTwoPlayerPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyTwoPlayerPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, reason := registry.Kick(packet.SenderID, packet.PartyID, packet.PlayerID)
-		reply(msg, success, reason)
-	})
+		success, reason := registry.Kick(ctx, packet.SenderID, packet.PartyID, packet.PlayerID)
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party kicks on subject '%s'", subject)
+	logger.Info("listening for party kicks", "subject", subject)
 }
 
 func stateHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.state.*.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyStateChangePacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyStateChangePacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyStateChangePacket message format", "data", string(msg.Data))
 			return
 		}
 
@@ -211,60 +299,171 @@ func stateHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 
 		switch action {
 		case "mute":
-			success, reason = registry.ToggleMute(packet.PlayerID, packet.PartyID, packet.State)
+			success, reason = registry.ToggleMute(ctx, packet.PlayerID, packet.PartyID, packet.State)
 			break
 		case "open_invites":
-			success, reason = registry.ToggleOpenInvites(packet.PlayerID, packet.PartyID, packet.State)
+			success, reason = registry.ToggleOpenInvites(ctx, packet.PlayerID, packet.PartyID, packet.State)
 			break
 		case "open":
-			success, reason = registry.ToggleOpen(packet.PlayerID, packet.PartyID, packet.State)
+			success, reason = registry.ToggleOpen(ctx, packet.PlayerID, packet.PartyID, packet.State)
 			break
 		default:
 			success = false
 			reason = "ERR_INVALID_ACTION"
-			log.Printf("Invalid party state change action: %s", action)
+			logger.WarnContext(ctx, "invalid party state change action", "action", action)
 			return
 		}
-		reply(msg, success, reason)
-	})
+		reply(ctx, msg, success, reason)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party state changes on subject '%s'", subject)
+	logger.Info("listening for party state changes", "subject", subject)
 }
 
 func fetchHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
 	const subject = "party.fetch.request"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyFetchRequest
+		if len(msg.Data) > 0 {
+			if err := json.Unmarshal(msg.Data, &packet); err != nil {
+				logger.WarnContext(ctx, "invalid PartyFetchRequest message format", "data", string(msg.Data))
+				reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+				return
+			}
+		}
 
-		data := registry.GetAllParties()
+		data := make([]parties.Party, 0, 1)
+		if packet.PartyID == nil && packet.PlayerID == nil {
+			data = registry.GetAllParties()
+		} else if party := registry.FindParty(packet.PartyID, packet.PlayerID); party != nil {
+			data = append(data, *party)
+		}
 		ack, err := json.Marshal(&data)
 		if err != nil {
-			log.Printf("Error marshalling fetch party packet response: %v", err)
+			logger.ErrorContext(ctx, "failed to marshal party list", "err", err)
 			return
 		}
-		err1 := msg.Respond(ack)
+		err1 := respond(ctx, msg, ack)
 		if err1 != nil {
-			log.Printf("Error sending acknowledgment: %v", err1)
+			logger.ErrorContext(ctx, "failed to send reply", "err", err1)
 		}
-	})
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party fetch requests", "subject", subject)
+}
+
+// syncHandler replies with the parties changed since the version a server already has
+func syncHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.sync"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartySyncRequest
+		if len(msg.Data) > 0 {
+			if err := json.Unmarshal(msg.Data, &packet); err != nil {
+				logger.WarnContext(ctx, "invalid PartySyncRequest message format", "data", string(msg.Data))
+				reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+				return
+			}
+		}
+
+		ack, err := json.Marshal(registry.Sync(packet.Epoch, packet.Since))
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to marshal party sync", "err", err)
+			return
+		}
+		if err := respond(ctx, msg, ack); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party sync requests", "subject", subject)
+}
+
+func listingHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.finder.update"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyListingPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyListingPacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		success, reason := registry.SetListing(ctx, packet.PlayerID, packet.PartyID, packet.Listing)
+		reply(ctx, msg, success, reason)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party finder listings", "subject", subject)
+}
+
+func successionHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.succession.update"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartySuccessionPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartySuccessionPacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		success, reason := registry.SetSuccession(ctx, packet.PlayerID, packet.PartyID, packet.Policy)
+		reply(ctx, msg, success, reason)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party succession changes", "subject", subject)
+}
+
+// finderHandler replies with the parties anyone can join, for the party finder
+func finderHandler(nc *nats.Conn, registry *parties.PartyRegistry) {
+	const subject = "party.finder.list"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyFinderRequest
+		if len(msg.Data) > 0 {
+			if err := json.Unmarshal(msg.Data, &packet); err != nil {
+				logger.WarnContext(ctx, "invalid PartyFinderRequest message format", "data", string(msg.Data))
+				reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+				return
+			}
+		}
+
+		ack, err := json.Marshal(&parties.PartyFinderResponse{Parties: registry.Find(ctx, packet)})
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to marshal party finder list", "err", err)
+			return
+		}
+		if err := respond(ctx, msg, ack); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party fetch requests on subject '%s'", subject)
+	logger.Info("listening for party finder lookups", "subject", subject)
 }
 
-func reply(msg *nats.Msg, success bool, reason string) {
+func reply(ctx context.Context, msg *nats.Msg, success bool, reason string) {
 	ack, err1 := json.Marshal(&parties.GenericPartyResponsePacket{
 		Success: success,
 		Message: reason,
 	})
 	if err1 != nil {
-		log.Printf("Error marshalling party packet response: %v", err1)
+		logger.ErrorContext(ctx, "failed to marshal party response", "err", err1)
 		return
 	}
-	if err := msg.Respond(ack); err != nil {
-		log.Printf("Error sending acknowledgment: %v", err)
+	if err := respond(ctx, msg, ack); err != nil {
+		logger.ErrorContext(ctx, "failed to send reply", "err", err)
 	}
 }
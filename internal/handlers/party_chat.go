@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/nats-io/nats.go"
+)
+
+func RegisterPartyChat(nc *nats.Conn, relay *parties.ChatRelay) {
+	chatHandler(nc, relay)
+}
+
+func chatHandler(nc *nats.Conn, relay *parties.ChatRelay) {
+	const subject = "party.chat.send"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyChatSendPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyChatSendPacket message format", "data", string(msg.Data))
+			return
+		}
+
+		success, reason := relay.Send(ctx, packet.PlayerID, packet.Message)
+		reply(ctx, msg, success, reason)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party chat", "subject", subject)
+}
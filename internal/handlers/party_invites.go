@@ -1,78 +1,140 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 
 	"github.com/CytonicMC/Cydian/internal/app"
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
 	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/CytonicMC/Cydian/internal/utils"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 )
 
 func RegisterPartyInvites(nc *nats.Conn, registry *parties.InviteRegistry, cydian *app.Cydian) {
 	acceptInviteHandler(nc, registry)
+	acceptInviteBySenderHandler(nc, registry)
+	acceptLatestInviteHandler(nc, registry)
 	sendInviteHandler(nc, registry, cydian)
+	declineInviteHandler(nc, registry)
+	revokeInviteHandler(nc, registry)
+	listIncomingInvitesHandler(nc, registry)
+	listOutgoingInvitesHandler(nc, registry)
 }
 
 // on accept
 func acceptInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry) {
 	const subject = "party.invites.accept"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyInviteAcceptPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyInviteAcceptPacket message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid PartyInviteAcceptPacket message format", "data", string(msg.Data))
 			return
 		}
 
-		success, req := registry.Accept(packet.RequestID)
-
-		// Add or update the server in the registry
-		if success {
+		req, errMsg := registry.Accept(ctx, packet.RequestID)
 
+		if req != nil {
 			ack, err1 := json.Marshal(&parties.GenericPartyResponsePacket{
 				Success: true,
 				Message: "",
 			})
 			if err1 != nil {
-				log.Printf("Error marshalling party invite response: %v", err1)
+				logger.ErrorContext(ctx, "failed to marshal party invite response", "err", err1)
 				return
 			}
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 
-			sendJoin(nc, *req)
+			sendJoin(ctx, nc, *req)
 		} else {
 			ack, err1 := json.Marshal(&parties.GenericPartyResponsePacket{
 				Success: false,
-				Message: "ERR_INVALID_INVITE",
+				Message: errMsg,
 			})
 			if err1 != nil {
-				log.Printf("Error marshalling party invite response: %v", err1)
+				logger.ErrorContext(ctx, "failed to marshal party invite response", "err", err1)
 				return
 			}
-			if err := msg.Respond(ack); err != nil {
-				log.Printf("Error sending acknowledgment: %v", err)
+			if err := respond(ctx, msg, ack); err != nil {
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 			}
 		}
-	})
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party invite acceptances", "subject", subject)
+}
+
+func acceptInviteBySenderHandler(nc *nats.Conn, registry *parties.InviteRegistry) {
+	const subject = "party.invites.accept.by_sender"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyInviteAcceptBySenderPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyInviteAcceptBySenderPacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		invite, errMsg := registry.AcceptFromSender(ctx, packet.RecipientID, packet.SenderID)
+		replyAccepted(ctx, nc, msg, invite, errMsg)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for party invite acceptances on subject '%s'", subject)
+	logger.Info("listening for party invite acceptances by sender", "subject", subject)
+}
+
+func acceptLatestInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry) {
+	const subject = "party.invites.accept.latest"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyInviteAcceptLatestPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyInviteAcceptLatestPacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		invite, errMsg := registry.AcceptLatest(ctx, packet.RecipientID)
+		replyAccepted(ctx, nc, msg, invite, errMsg)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for latest party invite acceptances", "subject", subject)
+}
+
+// replyAccepted answers with the accepted invite, since the requester didn't name it, and broadcasts the join
+func replyAccepted(ctx context.Context, nc *nats.Conn, msg *nats.Msg, invite *parties.PartyInvite, errMsg string) {
+	if invite == nil {
+		reply(ctx, msg, false, errMsg)
+		return
+	}
+	serialized, err := json.Marshal(invite)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal party invite", "err", err)
+		reply(ctx, msg, false, "ERR_MARSHAL_INVITE")
+		return
+	}
+	reply(ctx, msg, true, string(serialized))
+	sendJoin(ctx, nc, *invite)
 }
 
 func sendInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry, cydian *app.Cydian) {
 	const subject = "party.invites.send"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var packet parties.PartyInviteSendPacket
 		if err := json.Unmarshal(msg.Data, &packet); err != nil {
-			log.Printf("Invalid PartyInviteSendPacket message format: %s", msg.Data)
-			reply(msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			logger.WarnContext(ctx, "invalid PartyInviteSendPacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
 			return
 		}
 
@@ -81,7 +143,7 @@ func sendInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry, cydian *
 		partyID := packet.PartyID
 		if partyID == nil {
 			if isInParty {
-				sendReply(msg, &parties.GenericPartyResponsePacket{
+				sendReply(ctx, msg, &parties.GenericPartyResponsePacket{
 					Success: false,
 					Message: "ERR_STATE_MISMATCH_SERVER",
 				})
@@ -91,17 +153,17 @@ func sendInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry, cydian *
 			pID := parties.UUID(uuid.New())
 			partyID = &pID
 		} else if cydian.PartyRegistry.GetParty(*partyID) == nil {
-			sendReply(msg, &parties.GenericPartyResponsePacket{
+			sendReply(ctx, msg, &parties.GenericPartyResponsePacket{
 				Success: false,
 				Message: "ERR_STATE_MISMATCH_SERVICE",
 			})
 			return
 		}
 
-		invite, errMsg := registry.CreateInvite(packet.SenderID, *partyID, packet.RecipientID)
+		invite, errMsg := registry.CreateInvite(ctx, packet.SenderID, *partyID, packet.RecipientID, packet.SenderRank)
 
 		if len(errMsg) > 0 {
-			sendReply(msg, &parties.GenericPartyResponsePacket{
+			sendReply(ctx, msg, &parties.GenericPartyResponsePacket{
 				Success: false,
 				Message: errMsg,
 			})
@@ -109,19 +171,27 @@ func sendInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry, cydian *
 		}
 
 		if isNewParty {
-			cydian.PartyRegistry.CreateParty(*partyID, packet.SenderID, invite)
+			// the sender may have joined or created a party since IsInParty was checked
+			if _, errMsg := cydian.PartyRegistry.CreateParty(ctx, *partyID, packet.SenderID, invite); len(errMsg) > 0 {
+				registry.Revoke(ctx, invite.ID, packet.SenderID)
+				sendReply(ctx, msg, &parties.GenericPartyResponsePacket{
+					Success: false,
+					Message: errMsg,
+				})
+				return
+			}
 		}
 
 		serialized, err1 := json.Marshal(invite)
 		if err1 != nil {
-			log.Printf("Error marshalling party invite: %v", err1)
-			sendReply(msg, &parties.GenericPartyResponsePacket{
+			logger.ErrorContext(ctx, "failed to marshal party invite", "err", err1)
+			sendReply(ctx, msg, &parties.GenericPartyResponsePacket{
 				Success: false,
 				Message: "ERR_MARSHAL_INVITE",
 			})
 			return
 		}
-		sendReply(msg, &parties.GenericPartyResponsePacket{
+		sendReply(ctx, msg, &parties.GenericPartyResponsePacket{
 			Success: true,
 			Message: string(serialized),
 		})
@@ -131,41 +201,148 @@ func sendInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry, cydian *
 			ack, _ := json.Marshal(&parties.PartyInvitePacket{
 				Invite: *invite,
 			})
-			err := nc.Publish(env.EnsurePrefixed("party.invites.send.notify"), ack)
+			err := utils.Publish(ctx, nc, env.EnsurePrefixed("party.invites.send.notify"), ack)
 			if err != nil {
-				log.Printf("Error sending error party invite send announcment: %v", errMsg)
+				logger.ErrorContext(ctx, "failed to publish party invite", "invite", invite.ID, "err", err)
 			}
 		}
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party invite acceptances", "subject", subject)
+}
+
+func declineInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry) {
+	const subject = "party.invites.decline"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyInviteDeclinePacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyInviteDeclinePacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		invite, errMsg := registry.Decline(ctx, packet.RequestID, packet.PlayerID)
+		if invite == nil {
+			reply(ctx, msg, false, errMsg)
+			return
+		}
+		reply(ctx, msg, true, "")
+		sendInviteNotify(ctx, nc, "party.invites.decline.notify", *invite)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party invite declinations", "subject", subject)
+}
+
+func revokeInviteHandler(nc *nats.Conn, registry *parties.InviteRegistry) {
+	const subject = "party.invites.revoke"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyInviteRevokePacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyInviteRevokePacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		invite, errMsg := registry.Revoke(ctx, packet.RequestID, packet.SenderID)
+		if invite == nil {
+			reply(ctx, msg, false, errMsg)
+			return
+		}
+		reply(ctx, msg, true, "")
+		sendInviteNotify(ctx, nc, "party.invites.revoke.notify", *invite)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party invite revocations", "subject", subject)
+}
+
+func listIncomingInvitesHandler(nc *nats.Conn, registry *parties.InviteRegistry) {
+	const subject = "party.invites.list.incoming"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyInviteListRequest
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyInviteListRequest message format", "data", string(msg.Data))
+			return
+		}
+		sendInviteList(ctx, msg, registry.Incoming(packet.PlayerID))
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for incoming party invite lookups", "subject", subject)
+}
+
+func listOutgoingInvitesHandler(nc *nats.Conn, registry *parties.InviteRegistry) {
+	const subject = "party.invites.list.outgoing"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyInviteListRequest
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyInviteListRequest message format", "data", string(msg.Data))
+			return
+		}
+		sendInviteList(ctx, msg, registry.Outgoing(packet.PlayerID))
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for outgoing party invite lookups", "subject", subject)
+}
+
+func sendInviteList(ctx context.Context, msg *nats.Msg, invites []parties.PartyInvite) {
+	ack, err := json.Marshal(&parties.PartyInviteListResponse{Invites: invites})
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal party invites", "err", err)
+		return
+	}
+	if err := respond(ctx, msg, ack); err != nil {
+		logger.ErrorContext(ctx, "failed to send reply", "err", err)
+	}
+}
+
+// sendInviteNotify broadcasts an invite that was removed before it expired
+func sendInviteNotify(ctx context.Context, nc *nats.Conn, subject string, invite parties.PartyInvite) {
+	ack, err := json.Marshal(&parties.PartyInvitePacket{Invite: invite})
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal party invite", "invite", invite.ID, "err", err)
+		return
+	}
+	if err := utils.Publish(ctx, nc, env.EnsurePrefixed(subject), ack); err != nil {
+		logger.ErrorContext(ctx, "failed to publish party invite notice", "subject", subject, "invite", invite.ID, "err", err)
 	}
-	log.Printf("Listening for party invite acceptances on subject '%s'", subject)
 }
 
-func sendJoin(nc *nats.Conn, req parties.PartyInvite) {
+func sendJoin(ctx context.Context, nc *nats.Conn, req parties.PartyInvite) {
 
 	const subject = "party.invites.accept.notify"
 	marshal, errr := json.Marshal(req)
 	if errr != nil {
-		log.Printf("Error marshalling friend request: %v", errr)
+		logger.ErrorContext(ctx, "failed to marshal party invite", "invite", req.ID, "err", errr)
 		return
 	}
-	err := nc.Publish(env.EnsurePrefixed(subject), marshal)
+	err := utils.Publish(ctx, nc, env.EnsurePrefixed(subject), marshal)
 	if err != nil {
-		log.Printf("Error publishing friends acceptance message: %v", err)
+		logger.ErrorContext(ctx, "failed to publish party invite acceptance", "invite", req.ID, "err", err)
 		return
 	}
 }
 
-func sendReply(msg *nats.Msg, data *parties.GenericPartyResponsePacket) {
+func sendReply(ctx context.Context, msg *nats.Msg, data *parties.GenericPartyResponsePacket) {
 	ack, err1 := json.Marshal(data)
 	if err1 != nil {
-		log.Printf("Error marshalling party invite response: %v", err1)
+		logger.ErrorContext(ctx, "failed to marshal party response", "err", err1)
 		return
 	}
-	err := msg.Respond(ack)
+	err := respond(ctx, msg, ack)
 	if err != nil {
-		log.Printf("Error sending error message: %v", err)
+		logger.ErrorContext(ctx, "failed to send reply", "err", err)
 	}
 }
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/nats-io/nats.go"
+)
+
+func RegisterPartyJoinRequests(nc *nats.Conn, registry *parties.JoinRequestRegistry) {
+	sendJoinRequestHandler(nc, registry)
+	respondJoinRequestHandler(nc, registry)
+}
+
+func sendJoinRequestHandler(nc *nats.Conn, registry *parties.JoinRequestRegistry) {
+	const subject = "party.join_request.send"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyJoinRequestSendPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyJoinRequestSendPacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		request, errMsg := registry.Send(ctx, packet.PartyID, packet.PlayerID)
+		if request == nil {
+			reply(ctx, msg, false, errMsg)
+			return
+		}
+		reply(ctx, msg, true, "")
+
+		// the leader and moderators are told through the servers they are on
+		ack, _ := json.Marshal(&parties.PartyJoinRequestPacket{Request: *request})
+		if err := utils.Publish(ctx, nc, env.EnsurePrefixed("party.join_request.send.notify"), ack); err != nil {
+			logger.ErrorContext(ctx, "failed to publish party join request", "request", request.ID, "err", err)
+		}
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party join requests", "subject", subject)
+}
+
+func respondJoinRequestHandler(nc *nats.Conn, registry *parties.JoinRequestRegistry) {
+	const subject = "party.join_request.respond"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		var packet parties.PartyJoinRequestRespondPacket
+		if err := json.Unmarshal(msg.Data, &packet); err != nil {
+			logger.WarnContext(ctx, "invalid PartyJoinRequestRespondPacket message format", "data", string(msg.Data))
+			reply(ctx, msg, false, "ERR_INVALID_MESSAGE_FORMAT")
+			return
+		}
+
+		request, errMsg := registry.Respond(ctx, packet.RequestID, packet.ResponderID, packet.Approve)
+		if request == nil {
+			reply(ctx, msg, false, errMsg)
+			return
+		}
+		reply(ctx, msg, true, "")
+
+		ack, _ := json.Marshal(&parties.PartyJoinRequestResponsePacket{
+			Request:     *request,
+			ResponderID: packet.ResponderID,
+			Approved:    packet.Approve,
+		})
+		if err := utils.Publish(ctx, nc, env.EnsurePrefixed("party.join_request.respond.notify"), ack); err != nil {
+			logger.ErrorContext(ctx, "failed to publish party join request response", "request", request.ID, "err", err)
+		}
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for party join request responses", "subject", subject)
+}
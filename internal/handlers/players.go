@@ -1,54 +1,80 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 
 	"github.com/CytonicMC/Cydian/internal/app"
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
 	"github.com/CytonicMC/Cydian/internal/parties"
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 )
 
 type PlayerStatusPacket struct {
 	UUID     parties.UUID `json:"uuid"`
 	Username string       `json:"username"`
+	Rank     string       `json:"rank,omitempty"`
+	Server   string       `json:"server,omitempty"`
+}
+
+// PlayerServerPacket is sent by proxies when a player moves to another server
+type PlayerServerPacket struct {
+	UUID   parties.UUID `json:"uuid"`
+	Server string       `json:"server"`
 }
 
 // RegisterPlayerHandlers Register handlers for the various services that depend on player actions
 func RegisterPlayerHandlers(nc *nats.Conn, instance *app.Cydian) {
 	registerPlayerJoinHandler(nc, instance)
 	registerPlayerLeaveHandler(nc, instance)
+	registerPlayerServerHandler(nc, instance)
 }
 
 func registerPlayerJoinHandler(nc *nats.Conn, instance *app.Cydian) {
-	_, err := nc.Subscribe(env.EnsurePrefixed("players.connect"), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed("players.connect"), instrument("players.connect", func(ctx context.Context, msg *nats.Msg) {
 		obj := PlayerStatusPacket{}
 		err := json.Unmarshal(msg.Data, &obj)
 		if err != nil {
-			log.Println("Error parsing player status packet in PlayerID Join Handler: ", err)
+			logger.WarnContext(ctx, "invalid player status packet", "data", string(msg.Data), "err", err)
 			return
 		}
-		instance.PartyRegistry.HandleReconnect(obj.UUID)
-	})
+		instance.Presence.Connect(uuid.UUID(obj.UUID), obj.Username, obj.Rank, obj.Server)
+		instance.PartyRegistry.HandleReconnect(ctx, obj.UUID)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", "players.connect", err)
-		return
+		logging.Fatal(logger, "failed to subscribe", "subject", "players.connect", "err", err)
 	}
 }
 
 func registerPlayerLeaveHandler(nc *nats.Conn, instance *app.Cydian) {
-	_, err := nc.Subscribe(env.EnsurePrefixed("players.disconnect"), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed("players.disconnect"), instrument("players.disconnect", func(ctx context.Context, msg *nats.Msg) {
 		obj := PlayerStatusPacket{}
 		err := json.Unmarshal(msg.Data, &obj)
 		if err != nil {
-			log.Println("Error parsing player status packet in PlayerID Leave Handler: ", err)
+			logger.WarnContext(ctx, "invalid player status packet", "data", string(msg.Data), "err", err)
+			return
+		}
+		instance.Presence.Disconnect(uuid.UUID(obj.UUID))
+		instance.PartyRegistry.HandleDisconnect(ctx, obj.UUID)
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", "players.disconnect", "err", err)
+	}
+}
+
+func registerPlayerServerHandler(nc *nats.Conn, instance *app.Cydian) {
+	_, err := nc.Subscribe(env.EnsurePrefixed("players.server"), instrument("players.server", func(ctx context.Context, msg *nats.Msg) {
+		obj := PlayerServerPacket{}
+		err := json.Unmarshal(msg.Data, &obj)
+		if err != nil {
+			logger.WarnContext(ctx, "invalid player server packet", "data", string(msg.Data), "err", err)
 			return
 		}
-		instance.PartyRegistry.HandleDisconnect(obj.UUID)
-	})
+		instance.Presence.SetServer(uuid.UUID(obj.UUID), obj.Server)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", "players.disconnect", err)
-		return
+		logging.Fatal(logger, "failed to subscribe", "subject", "players.server", "err", err)
 	}
 }
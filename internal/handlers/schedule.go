@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/schedule"
+	"github.com/nats-io/nats.go"
+)
+
+func RegisterSchedule(nc *nats.Conn, scheduler *schedule.Scheduler) {
+	scheduleGetHandler(nc, scheduler)
+}
+
+// scheduleGetHandler replies with the scaling schedule and the current state of every scheduled type
+func scheduleGetHandler(nc *nats.Conn, scheduler *schedule.Scheduler) {
+	const subject = "instances.schedule.get"
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
+		response, err := json.Marshal(scheduler.Snapshot())
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to marshal schedule", "err", err)
+			return
+		}
+		if err := respond(ctx, msg, response); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	}))
+	if err != nil {
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
+	}
+	logger.Info("listening for schedule requests", "subject", subject)
+}
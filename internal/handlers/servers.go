@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
 	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/CytonicMC/Cydian/internal/utils"
 	"github.com/nats-io/nats.go"
 )
 
@@ -14,92 +16,141 @@ func RegisterServers(nc *nats.Conn, registry *servers.Registry) {
 	shutdownHandler(nc, registry)
 	listHandler(nc, registry)
 	proxyStartupHandler(nc, registry)
+	drainHandler(nc, registry)
 }
 
 // registrationHandler sets up the NATS subscription for server registration
 func registrationHandler(nc *nats.Conn, reg *servers.Registry) {
 	const subject = "servers.register"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var serverInfo servers.ServerInfo
 		if err := json.Unmarshal(msg.Data, &serverInfo); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			return
 		}
 
 		// Add or update the server in the registry
 		reg.AddOrUpdate(serverInfo)
-		log.Printf("Registered server: %s", serverInfo.ID)
 
 		// add them to the proxies at runtime
-		NotifyProxiesOfStartup(nc, serverInfo)
-	})
+		NotifyProxiesOfStartup(ctx, nc, serverInfo)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for server registrations on subject '%s'", subject)
+	logger.Info("listening for server registrations", "subject", subject)
 }
 
 // ShutdownHandler sets up the NATS subscription for server shut-downs (Graceful ones, anyway.)
 func shutdownHandler(nc *nats.Conn, reg *servers.Registry) {
 	const subject = "servers.shutdown"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		var serverInfo servers.ServerInfo
 		if err := json.Unmarshal(msg.Data, &serverInfo); err != nil {
-			log.Printf("Invalid message format: %s", msg.Data)
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
 			return
 		}
 
 		// Add or update the server in the registry
 		reg.Remove(serverInfo.ID)
-		log.Printf("Removed server: %s", serverInfo.ID)
 
 		// notify proxies that servers have been removed
-		NotifyProxiesOfShutdown(nc, serverInfo)
-	})
+		NotifyProxiesOfShutdown(ctx, nc, serverInfo)
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for server shutdown on subject '%s'", subject)
+	logger.Info("listening for server shutdown", "subject", subject)
 }
 
-func NotifyProxiesOfShutdown(nc *nats.Conn, serverInfo servers.ServerInfo) {
+func NotifyProxiesOfShutdown(ctx context.Context, nc *nats.Conn, serverInfo servers.ServerInfo) {
 	const subject = "servers.proxy.shutdown.notify"
 
 	data, err := json.Marshal(serverInfo)
 	if err != nil {
-		log.Printf("Failed to jsonify serverInfo")
+		logger.ErrorContext(ctx, "failed to marshal server info", "server", serverInfo.ID, "err", err)
 		return
 	}
 
-	err = nc.Publish(env.EnsurePrefixed(subject), data)
-	log.Printf("Notified proxies of shutdown for server: %s", serverInfo.ID)
+	if err := utils.Publish(ctx, nc, env.EnsurePrefixed(subject), data); err != nil {
+		logger.ErrorContext(ctx, "failed to notify proxies of server shutdown", "server", serverInfo.ID, "err", err)
+		return
+	}
+	logger.DebugContext(ctx, "notified proxies of server shutdown", "server", serverInfo.ID)
+}
+
+// drainHandler stops new players from being sent to a server, letting it empty out before it is stopped
+func drainHandler(nc *nats.Conn, reg *servers.Registry) {
+	const subject = "servers.drain"
+
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, staff(func(ctx context.Context, msg *nats.Msg) {
+		var serverInfo servers.ServerInfo
+		if err := json.Unmarshal(msg.Data, &serverInfo); err != nil {
+			logger.WarnContext(ctx, "invalid message format", "data", string(msg.Data))
+			return
+		}
+
+		response := servers.ServerResponse{Success: true, Message: "SUCCESS"}
+		if !DrainServer(ctx, nc, reg, serverInfo.ID) {
+			response = servers.ServerResponse{Success: false, Message: "SERVER_NOT_FOUND"}
+		}
+		ack, _ := json.Marshal(response)
+		if err := respond(ctx, msg, ack); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
+		}
+	})))
 	if err != nil {
-		log.Printf("Failed to publish a server shutdown message: %v", err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
+	logger.Info("listening for server drains", "subject", subject)
 }
 
-func NotifyProxiesOfStartup(nc *nats.Conn, serverInfo servers.ServerInfo) {
+// DrainServer marks the server as draining and tells proxies to stop routing players to it
+func DrainServer(ctx context.Context, nc *nats.Conn, reg *servers.Registry, id string) bool {
+	const subject = "servers.drain.notify"
+
+	serverInfo, ok := reg.Drain(id)
+	if !ok {
+		return false
+	}
+
+	data, err := json.Marshal(servers.DrainNotify{
+		ID:     serverInfo.ID,
+		Type:   serverInfo.Type,
+		Reason: "Drained by an administrator",
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal drain notification", "server", id, "err", err)
+		return true
+	}
+	if err := utils.Publish(ctx, nc, env.EnsurePrefixed(subject), data); err != nil {
+		logger.ErrorContext(ctx, "failed to publish drain notification", "server", id, "err", err)
+	}
+	return true
+}
+
+func NotifyProxiesOfStartup(ctx context.Context, nc *nats.Conn, serverInfo servers.ServerInfo) {
 	const subject = "servers.proxy.startup.notify"
 
 	data, err := json.Marshal(serverInfo)
 	if err != nil {
-		log.Printf("Failed to jsonify serverInfo")
+		logger.ErrorContext(ctx, "failed to marshal server info", "server", serverInfo.ID, "err", err)
 		return
 	}
 
-	err = nc.Publish(env.EnsurePrefixed(subject), data)
-	log.Printf("published server startup proxy notification")
-	if err != nil {
-		log.Printf("Failed to publish a server startup message: %v", err)
+	if err := utils.Publish(ctx, nc, env.EnsurePrefixed(subject), data); err != nil {
+		logger.ErrorContext(ctx, "failed to notify proxies of server startup", "server", serverInfo.ID, "err", err)
+		return
 	}
+	logger.DebugContext(ctx, "notified proxies of server startup", "server", serverInfo.ID)
 }
 
 // ListHandler Handles NATS requests by replying will all the registered servers
 func listHandler(nc *nats.Conn, reg *servers.Registry) {
 	const subject = "servers.list"
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		// Fetch all registered all
 		all := servers.ServerList{
 			Servers: reg.GetAll(),
@@ -107,47 +158,47 @@ func listHandler(nc *nats.Conn, reg *servers.Registry) {
 		// Serialize to JSON
 		response, err := json.Marshal(all)
 		if err != nil {
-			log.Printf("Error marshalling all list: %v", err)
-			err := msg.Respond([]byte("Error generating server list"))
+			logger.ErrorContext(ctx, "failed to marshal server list", "err", err)
+			err := respond(ctx, msg, []byte("Error generating server list"))
 			if err != nil {
-				log.Printf("Error sending acknowledgment ;-; -> %v", err)
+				logger.ErrorContext(ctx, "failed to send reply", "err", err)
 				return
 			}
 			return
 		}
 
 		// Send response
-		if err := msg.Respond(response); err != nil {
-			log.Printf("Error responding to server list request: %v", err)
+		if err := respond(ctx, msg, response); err != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err)
 		}
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for server list requests on subject '%s'", subject)
+	logger.Info("listening for server list requests", "subject", subject)
 }
 
 func proxyStartupHandler(nc *nats.Conn, reg *servers.Registry) {
 	const subject = "servers.proxy.startup"
 
-	_, err := nc.Subscribe(env.EnsurePrefixed(subject), func(msg *nats.Msg) {
+	_, err := nc.Subscribe(env.EnsurePrefixed(subject), instrument(subject, func(ctx context.Context, msg *nats.Msg) {
 		// don't care about the message
 
 		ack, err := json.Marshal(servers.ServerList{Servers: reg.GetAll()})
 
 		if err != nil {
-			log.Printf("Error marshalling all list: %v", err)
+			logger.ErrorContext(ctx, "failed to marshal server list", "err", err)
 			ack = []byte("ERROR: Failed to marshal all servers")
 		}
 
 		// either respond with the json data, or the error message
-		if err1 := msg.Respond(ack); err1 != nil {
-			log.Printf("Error sending acknowledgment: %v", err1)
+		if err1 := respond(ctx, msg, ack); err1 != nil {
+			logger.ErrorContext(ctx, "failed to send reply", "err", err1)
 		}
 
-	})
+	}))
 	if err != nil {
-		log.Fatalf("Error subscribing to subject %s: %v", subject, err)
+		logging.Fatal(logger, "failed to subscribe", "subject", subject, "err", err)
 	}
-	log.Printf("Listening for proxy startup on subject '%s'", subject)
+	logger.Info("listening for proxy startup", "subject", subject)
 }
@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/hashicorp/nomad/api"
+	"github.com/nats-io/nats.go"
+)
+
+// NatsCheck fails while the connection is down, or while subscriptions haven't been verified after a reconnect
+func NatsCheck(nc *nats.Conn) func() Check {
+	return func() Check {
+		if !NatsConnected() {
+			return Fail(fmt.Sprintf("connection is %s", nc.Status()))
+		}
+		return OK(fmt.Sprintf("connected to %s", nc.ConnectedUrlRedacted()))
+	}
+}
+
+// NatsClosedCheck only fails once the connection is closed for good, when reconnecting has given up
+func NatsClosedCheck(nc *nats.Conn) func() Check {
+	return func() Check {
+		if nc.IsClosed() {
+			return Fail("connection is closed")
+		}
+		return OK(nc.Status().String())
+	}
+}
+
+// SubscriptionsCheck fails unless a request makes it through the server to one of our own subscriptions
+func SubscriptionsCheck(nc *nats.Conn, probe func() error) func() Check {
+	return func() Check {
+		if err := probe(); err != nil {
+			return Fail(fmt.Sprintf("%d subscriptions, not answering: %v", nc.NumSubscriptions(), err))
+		}
+		return OK(fmt.Sprintf("%d subscriptions answering", nc.NumSubscriptions()))
+	}
+}
+
+// NomadCheck fails if the Nomad API can't report a cluster leader within the timeout
+func NomadCheck(client *api.Client, timeout time.Duration) func() Check {
+	return func() Check {
+		result := make(chan Check, 1)
+		go func() {
+			done := tracing.Nomad(context.Background(), "status.leader")
+			leader, err := client.Status().Leader()
+			done(err)
+			if err != nil {
+				result <- Fail(err.Error())
+				return
+			}
+			result <- OK("leader " + leader)
+		}()
+		select {
+		case c := <-result:
+			return c
+		case <-time.After(timeout):
+			return Fail(fmt.Sprintf("no response within %s", timeout))
+		}
+	}
+}
+
+// SweepCheck fails if the last server health check sweep is older than maxAge
+func SweepCheck(last func() time.Time, maxAge func() time.Duration, started time.Time) func() Check {
+	return func() Check {
+		l := last()
+		if l.IsZero() {
+			// the first sweep only runs after one interval
+			if time.Since(started) > maxAge() {
+				return Fail("no sweep has completed since startup")
+			}
+			return OK("waiting for the first sweep")
+		}
+		if age := time.Since(l); age > maxAge() {
+			return Fail(fmt.Sprintf("last sweep completed %s ago", age.Round(time.Second)))
+		}
+		return OK(fmt.Sprintf("last sweep at %s", l.UTC().Format(time.RFC3339)))
+	}
+}
+
+// EventStreamCheck fails while the last event publish failed, reporting how many events are queued
+func EventStreamCheck(lastErr func() error, pending func() int) func() Check {
+	return func() Check {
+		if err := lastErr(); err != nil {
+			return Fail(fmt.Sprintf("%s, %d events queued", err, pending()))
+		}
+		return OK(fmt.Sprintf("%d events queued", pending()))
+	}
+}
+
+// PersistenceCheck reports that party state only lives in memory, without an event stream to publish it to
+func PersistenceCheck() Check {
+	return OK("party state is in-memory only, no event stream configured")
+}
+
+// BucketCheck fails if a key-value bucket's status can't be read within the timeout. status reports what
+// the bucket holds, and succeeds without a bucket when state is only kept in memory.
+func BucketCheck(status func(ctx context.Context) (string, error), timeout time.Duration) func() Check {
+	return func() Check {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		detail, err := status(ctx)
+		if err != nil {
+			return Fail(err.Error())
+		}
+		return OK(detail)
+	}
+}
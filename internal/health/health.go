@@ -0,0 +1,134 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/logging"
+)
+
+var logger = logging.For("health")
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is the result of a single health check
+type Check struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the combined result of every check of a kind
+type Report struct {
+	Status    string           `json:"status"`
+	Checks    map[string]Check `json:"checks"`
+	CheckedAt time.Time        `json:"checked_at"`
+}
+
+type check struct {
+	name string
+	fn   func() Check
+}
+
+var (
+	natsConnected atomic.Bool
+
+	mu        sync.Mutex
+	liveness  []check
+	readiness []check
+)
+
+// SetNatsConnected marks the service healthy or unhealthy depending on the NATS connection
+func SetNatsConnected(connected bool) {
+	natsConnected.Store(connected)
+}
+
+// NatsConnected reports whether the NATS connection is currently up
+func NatsConnected() bool {
+	return natsConnected.Load()
+}
+
+// RegisterLiveness adds a check to /healthz. A failing liveness check means the process should be restarted,
+// so only register checks that a restart can fix.
+func RegisterLiveness(name string, fn func() Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	liveness = append(liveness, check{name: name, fn: fn})
+}
+
+// RegisterReadiness adds a check to /readyz and the cydian.health reply
+func RegisterReadiness(name string, fn func() Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	readiness = append(readiness, check{name: name, fn: fn})
+}
+
+// Liveness runs every liveness check
+func Liveness() Report {
+	return run(&liveness)
+}
+
+// Readiness runs every readiness check
+func Readiness() Report {
+	return run(&readiness)
+}
+
+// Serve adds /healthz and /readyz to the HTTP server that also serves /metrics
+func Serve() {
+	http.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		write(w, Liveness())
+	})
+	http.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		write(w, Readiness())
+	})
+}
+
+// OK and Fail build check results
+func OK(detail string) Check {
+	return Check{Status: StatusOK, Detail: detail}
+}
+
+func Fail(detail string) Check {
+	return Check{Status: StatusFail, Detail: detail}
+}
+
+func run(checks *[]check) Report {
+	mu.Lock()
+	toRun := append([]check(nil), *checks...)
+	mu.Unlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Check, len(toRun)), CheckedAt: time.Now()}
+	results := make([]Check, len(toRun))
+	var wg sync.WaitGroup
+	for i, c := range toRun {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.fn()
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range toRun {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func write(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Error("failed to write health report", "err", err)
+	}
+}
@@ -1,10 +1,18 @@
 package instances
 
+import "github.com/google/uuid"
+
 type InstanceCreateRequest struct {
 	InstanceType string `json:"instanceType"`
 	Quantity     int    `json:"quantity"`
 }
 
+// InstanceScaleRequest sets the instance count of a type, instead of adding to it like InstanceCreateRequest
+type InstanceScaleRequest struct {
+	InstanceType string `json:"instanceType"`
+	Count        int    `json:"count"`
+}
+
 type InstanceResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
@@ -22,3 +30,26 @@ type InstanceDeleteRequest struct {
 	InstanceType string `json:"instanceType"`
 	AllocId      string `json:"allocId"`
 }
+
+type PrivateInstanceCreateRequest struct {
+	InstanceType        string            `json:"instanceType"` // the parameterized Nomad job to dispatch
+	PartyID             *uuid.UUID        `json:"partyId"`      // may be nil for events that aren't tied to a party
+	Players             []uuid.UUID       `json:"players"`
+	Meta                map[string]string `json:"meta"`                // passed through to the dispatched job
+	EmptyTimeoutSeconds int               `json:"emptyTimeoutSeconds"` // 0 uses the registry default
+}
+
+type PrivateInstanceResponse struct {
+	Success  bool             `json:"success"`
+	Message  string           `json:"message"`
+	Instance *PrivateInstance `json:"instance"`
+}
+
+type PrivateInstanceOccupancy struct {
+	ID      string `json:"id"`
+	Players int    `json:"players"`
+}
+
+type PrivateInstanceList struct {
+	Instances []PrivateInstance `json:"instances"`
+}
@@ -0,0 +1,216 @@
+package instances
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/hashicorp/nomad/api"
+)
+
+var logger = logging.For("instances")
+
+// MetaInstanceID is the dispatch meta key carrying the private instance id. Dispatched servers must
+// register with this value (available as NOMAD_META_cydian_instance_id) as their server id.
+const MetaInstanceID = "cydian_instance_id"
+
+// PrivateInstance is a dedicated server dispatched for a party or an event
+type PrivateInstance struct {
+	ID           string              `json:"id"`
+	InstanceType string              `json:"instanceType"`
+	JobID        string              `json:"jobId"` // the dispatched child job, deregistered on teardown
+	PartyID      *uuid.UUID          `json:"partyId"`
+	Players      []uuid.UUID         `json:"players"`
+	Server       *servers.ServerInfo `json:"server"`
+	PlayerCount  int                 `json:"playerCount"`
+	EmptyTimeout time.Duration       `json:"emptyTimeout"`
+	EmptySince   *time.Time          `json:"emptySince"` // nil while players are online
+	CreatedAt    time.Time           `json:"createdAt"`
+}
+
+// PrivateRegistry tracks dispatched private instances and tears them down once they are empty
+type PrivateRegistry struct {
+	mu        sync.Mutex
+	instances map[string]*PrivateInstance
+	client    *api.Client
+	servers   *servers.Registry
+}
+
+// NewPrivateRegistry creates a new PrivateRegistry instance
+func NewPrivateRegistry(client *api.Client, servers *servers.Registry) *PrivateRegistry {
+	return &PrivateRegistry{
+		instances: make(map[string]*PrivateInstance),
+		client:    client,
+		servers:   servers,
+	}
+}
+
+// Create dispatches the parameterized job for the requested type and blocks until the resulting server
+// registers. On failure, an error code is returned and the dispatched job (if any) is stopped.
+func (r *PrivateRegistry) Create(ctx context.Context, req PrivateInstanceCreateRequest) (created *PrivateInstance, error string) {
+	ctx, span := tracing.Start(ctx, "PrivateRegistry.Create")
+	defer span.End()
+	defer func() {
+		entry := audit.Entry{
+			Actor:  audit.Actor(ctx, audit.ActorSystem),
+			Action: "instances.private.create",
+			Target: req.InstanceType,
+			Result: audit.Result(created != nil, error),
+		}
+		if req.PartyID != nil {
+			entry.Party = req.PartyID.String()
+		}
+		if created != nil {
+			entry.Params = map[string]any{"instance": created.ID}
+		}
+		audit.Record(ctx, entry)
+	}()
+	if req.InstanceType == "" {
+		return nil, "INVALID_INSTANCE_TYPE"
+	}
+
+	id := uuid.New().String()
+	meta := make(map[string]string, len(req.Meta)+3)
+	for k, v := range req.Meta {
+		meta[k] = v
+	}
+	meta[MetaInstanceID] = id
+	if req.PartyID != nil {
+		meta["cydian_party_id"] = req.PartyID.String()
+	}
+	if len(req.Players) > 0 {
+		players := make([]string, 0, len(req.Players))
+		for _, p := range req.Players {
+			players = append(players, p.String())
+		}
+		meta["cydian_players"] = strings.Join(players, ",")
+	}
+
+	// reserve before dispatching, the server may register before WaitFor is reached
+	r.servers.Reserve(id, req.PartyID, req.Players)
+	done := tracing.Nomad(ctx, "jobs.dispatch")
+	resp, _, err := r.client.Jobs().Dispatch(req.InstanceType, meta, nil, "", nil)
+	done(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to dispatch private instance", "type", req.InstanceType, "err", err)
+		r.servers.Release(id)
+		return nil, "JOB_DISPATCH_FAILED"
+	}
+	logger.InfoContext(ctx, "dispatched private instance", "instance", id, "job", resp.DispatchedJobID)
+
+	cfg := config.Get().Instances
+	emptyTimeout := cfg.PrivateEmptyTimeout.D()
+	if req.EmptyTimeoutSeconds > 0 {
+		emptyTimeout = time.Duration(req.EmptyTimeoutSeconds) * time.Second
+	}
+
+	server, ok := r.servers.WaitFor(id, cfg.PrivateStartupTimeout.D())
+	if !ok {
+		logger.WarnContext(ctx, "private instance did not register in time, stopping it", "instance", id, "timeout", cfg.PrivateStartupTimeout.D())
+		r.deregister(ctx, resp.DispatchedJobID)
+		r.servers.Release(id)
+		return nil, "REGISTRATION_TIMEOUT"
+	}
+
+	now := time.Now()
+	instance := &PrivateInstance{
+		ID:           id,
+		InstanceType: req.InstanceType,
+		JobID:        resp.DispatchedJobID,
+		PartyID:      req.PartyID,
+		Players:      req.Players,
+		Server:       server,
+		EmptyTimeout: emptyTimeout,
+		EmptySince:   &now, // nobody has joined yet
+		CreatedAt:    now,
+	}
+
+	r.mu.Lock()
+	r.instances[id] = instance
+	r.mu.Unlock()
+
+	logger.InfoContext(ctx, "private instance registered", "instance", id, "ip", server.IP, "port", server.Port)
+	return instance.copy(), ""
+}
+
+// UpdateOccupancy records the number of players reported by a private instance
+func (r *PrivateRegistry) UpdateOccupancy(id string, players int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	instance, ok := r.instances[id]
+	if !ok {
+		return false
+	}
+	instance.PlayerCount = players
+	if players > 0 {
+		instance.EmptySince = nil
+	} else if instance.EmptySince == nil {
+		now := time.Now()
+		instance.EmptySince = &now
+	}
+	return true
+}
+
+// Reap tears down every private instance that has been empty for longer than its timeout
+func (r *PrivateRegistry) Reap() {
+	r.mu.Lock()
+	expired := make([]*PrivateInstance, 0)
+	for id, instance := range r.instances {
+		if instance.EmptySince != nil && time.Since(*instance.EmptySince) > instance.EmptyTimeout {
+			expired = append(expired, instance)
+			delete(r.instances, id)
+		}
+	}
+	r.mu.Unlock()
+
+	// don't hold the lock across nomad calls
+	for _, instance := range expired {
+		logger.Info("private instance is empty, tearing it down", "instance", instance.ID, "empty_timeout", instance.EmptyTimeout)
+		ctx := context.Background()
+		result := audit.Result(r.deregister(ctx, instance.JobID), "JOB_DEREGISTER_FAILED")
+		r.servers.Release(instance.ID)
+		audit.Record(ctx, audit.Entry{
+			Actor:  audit.ActorSystem,
+			Action: "instances.private.teardown",
+			Target: instance.ID,
+			Params: map[string]any{"type": instance.InstanceType, "job": instance.JobID},
+			Result: result,
+		})
+	}
+}
+
+// GetAll returns all active private instances
+func (r *PrivateRegistry) GetAll() []PrivateInstance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := make([]PrivateInstance, 0, len(r.instances))
+	for _, instance := range r.instances {
+		all = append(all, *instance.copy())
+	}
+	return all
+}
+
+func (r *PrivateRegistry) deregister(ctx context.Context, jobID string) bool {
+	done := tracing.Nomad(ctx, "jobs.deregister")
+	_, _, err := r.client.Jobs().Deregister(jobID, true, nil)
+	done(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to deregister private instance job", "job", jobID, "err", err)
+		return false
+	}
+	return true
+}
+
+func (p *PrivateInstance) copy() *PrivateInstance {
+	c := *p
+	c.Players = append([]uuid.UUID(nil), p.Players...)
+	return &c
+}
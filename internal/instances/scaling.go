@@ -0,0 +1,58 @@
+package instances
+
+import (
+	"context"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/hashicorp/nomad/api"
+)
+
+// GroupCount returns the current count of the task group named after the instance type. The job is expected
+// to share its name with the task group, as it does for every server type.
+func GroupCount(ctx context.Context, client *api.Client, instanceType string) (int, string) {
+	done := tracing.Nomad(ctx, "jobs.info")
+	job, _, err := client.Jobs().Info(instanceType, nil)
+	done(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get job info", "job", instanceType, "err", err)
+		return 0, "JOB_NOT_FOUND"
+	}
+
+	for _, group := range job.TaskGroups {
+		if *group.Name == instanceType && group.Count != nil {
+			return *group.Count, ""
+		}
+	}
+	return 0, ""
+}
+
+// ScaleTo sets the task group of the instance type to exactly count instances
+func ScaleTo(ctx context.Context, client *api.Client, instanceType string, count int, message string) (error string) {
+	defer func() {
+		audit.Record(ctx, audit.Entry{
+			Actor:  audit.Actor(ctx, audit.ActorSystem),
+			Action: "instances.scale",
+			Target: instanceType,
+			Params: map[string]any{"count": count, "reason": message},
+			Result: audit.Result(error == "", error),
+		})
+	}()
+	done := tracing.Nomad(ctx, "jobs.info")
+	job, _, err := client.Jobs().Info(instanceType, nil)
+	done(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get job info", "job", instanceType, "err", err)
+		return "JOB_NOT_FOUND"
+	}
+
+	done = tracing.Nomad(ctx, "jobs.scale")
+	_, _, err = client.Jobs().Scale(*job.ID, instanceType, &count, message, false, nil, nil)
+	done(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to scale job", "job", instanceType, "count", count, "err", err)
+		return "JOB_SCALING_FAILED"
+	}
+	logger.InfoContext(ctx, "scaled instances", "type", instanceType, "count", count, "reason", message)
+	return ""
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// CorrelationHeader is the NATS header carrying the correlation ID from a request to every
+// notification it causes
+const CorrelationHeader = "Cydian-Correlation-Id"
+
+type (
+	correlationKey struct{}
+	attrsKey       struct{}
+)
+
+// WithCorrelationID returns a context carrying the correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by the context, or "" if there is none
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// With returns a context whose records carry the given attributes, ie: the subject being handled
+func With(ctx context.Context, args ...any) context.Context {
+	existing, _ := ctx.Value(attrsKey{}).([]any)
+	return context.WithValue(ctx, attrsKey{}, append(existing[:len(existing):len(existing)], args...))
+}
+
+func attrs(ctx context.Context) []any {
+	if ctx == nil {
+		return nil
+	}
+	a, _ := ctx.Value(attrsKey{}).([]any)
+	return a
+}
+
+// FromMsg returns a context carrying the message's correlation ID. Messages without one,
+// ie: from servers that don't set the header yet, start a new correlation.
+func FromMsg(ctx context.Context, msg *nats.Msg) context.Context {
+	id := msg.Header.Get(CorrelationHeader)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	return WithCorrelationID(ctx, id)
+}
+
+// NewMsg builds a message for subject with the context's correlation ID as a header
+func NewMsg(ctx context.Context, subject string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	if id := CorrelationID(ctx); id != "" {
+		msg.Header.Set(CorrelationHeader, id)
+	}
+	return msg
+}
@@ -0,0 +1,142 @@
+// Package logging configures Cydian's structured logging. Every subsystem logs through its own
+// logger from For, which tags each record with the subsystem and, when logging with a context,
+// the correlation ID of the request being handled.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	level slog.LevelVar
+	root  atomic.Pointer[slog.Handler]
+)
+
+func init() {
+	setRoot(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &level}))
+}
+
+// Setup installs the root handler. format is "text" or "json"; level is one of ParseLevel's names.
+// Loggers created before Setup pick up the new handler as well.
+func Setup(w io.Writer, format, lvl string) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: &level}
+	switch strings.ToLower(format) {
+	case "", "text":
+		setRoot(slog.NewTextHandler(w, opts))
+	case "json":
+		setRoot(slog.NewJSONHandler(w, opts))
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+func setRoot(h slog.Handler) {
+	h = contextHandler{h}
+	root.Store(&h)
+	// route the standard library logger (and anything else using slog's default) through the same handler
+	slog.SetDefault(slog.New(lazyHandler{}))
+	log.SetFlags(0)
+}
+
+// SetLevel changes the minimum level at runtime
+func SetLevel(lvl string) error {
+	parsed, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(parsed)
+	return nil
+}
+
+// ParseLevel accepts debug, info, warn and error. An empty string is info.
+func ParseLevel(lvl string) (slog.Level, error) {
+	switch strings.ToLower(lvl) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", lvl)
+	}
+}
+
+// For returns the logger of a subsystem, ie: For("parties")
+func For(subsystem string) *slog.Logger {
+	return slog.New(lazyHandler{}).With("subsystem", subsystem)
+}
+
+// lazyHandler resolves the root handler on every record, so package level loggers created
+// during init still follow Setup. Attributes and groups are replayed on top of the root.
+type lazyHandler struct {
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h lazyHandler) resolve() slog.Handler {
+	resolved := *root.Load()
+	for _, w := range h.wrap {
+		resolved = w(resolved)
+	}
+	return resolved
+}
+
+func (h lazyHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return (*root.Load()).Enabled(ctx, l)
+}
+
+func (h lazyHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.resolve().Handle(ctx, r)
+}
+
+func (h lazyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler { return inner.WithAttrs(attrs) })
+}
+
+func (h lazyHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler { return inner.WithGroup(name) })
+}
+
+func (h lazyHandler) with(w func(slog.Handler) slog.Handler) slog.Handler {
+	return lazyHandler{wrap: append(h.wrap[:len(h.wrap):len(h.wrap)], w)}
+}
+
+// contextHandler adds the correlation ID and attributes carried by the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := CorrelationID(ctx); id != "" {
+		r.AddAttrs(slog.String("correlation_id", id))
+	}
+	r.Add(attrs(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Fatal logs at error level and exits, the slog counterpart of log.Fatalf
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Sample is a single value of a gauge computed at scrape time
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type gaugeFunc struct {
+	desc    *prometheus.Desc
+	collect func() []Sample
+}
+
+// RegisterGaugeFunc registers a gauge whose values are read from collect on every scrape. This keeps
+// registries free of bookkeeping: their current state is the metric.
+func RegisterGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	Registry.MustRegister(&gaugeFunc{
+		desc:    prometheus.NewDesc(name, help, labels, nil),
+		collect: collect,
+	})
+}
+
+func (g *gaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *gaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range g.collect() {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, s.Value, s.Labels...)
+	}
+}
@@ -2,40 +2,129 @@ package metrics
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/CytonicMC/Cydian/internal/logging"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var logger = logging.For("metrics")
+
+// Registry holds every Cydian metric. It is separate from the global prometheus registry so
+// tests can create their own collectors and assert values without interference.
+var Registry = prometheus.NewRegistry()
+
 // Metrics to track
 var (
-	RegistrySize = prometheus.NewGauge(
+	HealthCheckDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "server_health_check_duration_seconds",
+			Help:    "Time taken by a single server to answer its health check",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 11), // 5ms to ~5s
+		},
+	)
+	HealthCheckFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "server_health_check_failures_total",
+			Help: "Total number of servers removed after failing a health check",
+		},
+		[]string{"type"},
+	)
+	Requests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_requests_total",
+			Help: "Total number of NATS messages handled, by subscription subject",
+		},
+		[]string{"subject"},
+	)
+	RequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nats_request_duration_seconds",
+			Help:    "Time taken to handle a NATS message, by subscription subject",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"subject"},
+	)
+	Replies = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_replies_total",
+			Help: "Total number of NATS replies sent, by subscription subject and result code",
+		},
+		[]string{"subject", "code"},
+	)
+	NomadCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nomad_calls_total",
+			Help: "Total number of Nomad API calls, by operation and outcome",
+		},
+		[]string{"operation", "outcome"},
+	)
+	NomadCallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nomad_call_duration_seconds",
+			Help:    "Time taken by Nomad API calls, by operation",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation"},
+	)
+	NatsConnected = prometheus.NewGauge(
 		prometheus.GaugeOpts{
-			Name: "server_registry_size",
-			Help: "Number of active servers in the registry",
+			Name: "nats_connected",
+			Help: "Whether the NATS connection is currently up (1) or not (0)",
+		},
+	)
+	NatsReconnects = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_reconnects_total",
+			Help: "Total number of times the NATS connection was re-established",
+		},
+	)
+	AuditDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_entries_dropped_total",
+			Help: "Total number of audit entries dropped because the sink's queue was full",
 		},
 	)
-	RequestCount = prometheus.NewCounterVec(
+	NatsErrors = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "server_registration_requests_total",
-			Help: "Total number of server registration requests received",
+			Name: "nats_async_errors_total",
+			Help: "Total number of asynchronous NATS errors, ie: slow consumers",
 		},
-		[]string{"status"}, // Labels: status (e.g., success, error)
 	)
 )
 
 // InitMetrics initializes and registers Prometheus metrics
 func InitMetrics() {
 	// Register metrics
-	prometheus.MustRegister(RegistrySize, RequestCount)
+	Registry.MustRegister(
+		HealthCheckDuration, HealthCheckFailures,
+		Requests, RequestDuration, Replies,
+		NomadCalls, NomadCallDuration,
+		NatsConnected, NatsReconnects, NatsErrors,
+		AuditDropped,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
 }
 
-// ServeMetrics starts an HTTP server to expose metrics
-func ServeMetrics() {
-	http.Handle("/metrics", promhttp.Handler())
+// ServeMetrics starts an HTTP server on the given address to expose metrics
+func ServeMetrics(address string) {
+	http.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
 	go func() {
-		if err := http.ListenAndServe(":8081", nil); err != nil {
-			panic(err)
+		if err := http.ListenAndServe(address, nil); err != nil {
+			logging.Fatal(logger, "failed to serve metrics", "address", address, "err", err)
 		}
 	}()
 }
+
+// ObserveNomad records the latency and outcome of a Nomad API call started at start
+func ObserveNomad(operation string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	NomadCalls.WithLabelValues(operation, outcome).Inc()
+	NomadCallDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,265 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/instances"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/hashicorp/nomad/api"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var logger = logging.For("network")
+
+// Maintenance holds the maintenance switch of the environment this instance serves. Since every subject is
+// prefixed per environment, locking alpha leaves production untouched.
+type Maintenance struct {
+	mu     sync.Mutex
+	state  MaintenanceState
+	nc     *nats.Conn
+	scaler Scaler
+	// serializes Set, which scales through Nomad without holding mu so Admit never waits on it
+	setMu sync.Mutex
+	// persists the state, so the counts to restore survive a restart. nil without JetStream.
+	kv jetstream.KeyValue
+}
+
+// Scaler reads and sets the instance count of server types
+type Scaler interface {
+	GroupCount(ctx context.Context, serverType string) (int, string)
+	ScaleTo(ctx context.Context, serverType string, count int, message string) string
+}
+
+// nomadScaler scales the Nomad task group named after the server type
+type nomadScaler struct {
+	client *api.Client
+}
+
+func (s nomadScaler) GroupCount(ctx context.Context, serverType string) (int, string) {
+	return instances.GroupCount(ctx, s.client, serverType)
+}
+
+func (s nomadScaler) ScaleTo(ctx context.Context, serverType string, count int, message string) string {
+	return instances.ScaleTo(ctx, s.client, serverType, count, message)
+}
+
+// stateKey is the key of the state in the maintenance bucket, which is already per environment
+const stateKey = "state"
+
+// NewMaintenance creates a new Maintenance instance, picking up the state persisted by the previous run.
+// Otherwise, it starts enabled if the current environment is listed in the maintenance.environments
+// setting (ie: ["ALPHA", "DEVELOPMENT"]).
+func NewMaintenance(nc *nats.Conn, client *api.Client) *Maintenance {
+	return newMaintenance(nc, nomadScaler{client})
+}
+
+func newMaintenance(nc *nats.Conn, scaler Scaler) *Maintenance {
+	m := &Maintenance{
+		state: MaintenanceState{
+			Environment: env.Environment(),
+			ScaledDown:  make(map[string]int),
+		},
+		nc:     nc,
+		scaler: scaler,
+	}
+	m.load()
+	if m.state.Enabled {
+		return m
+	}
+	for _, name := range config.Get().Maintenance.Environments {
+		if strings.TrimSpace(name) != "" && env.PrefixFor(name) == env.Prefix() {
+			now := time.Now()
+			m.state.Enabled = true
+			m.state.Since = &now
+			logger.Info("starting with network maintenance enabled", "environment", env.Environment())
+			break
+		}
+	}
+	return m
+}
+
+// load opens the maintenance bucket and reads the persisted state, if any
+func (m *Maintenance) load() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	js, err := jetstream.New(m.nc)
+	if err == nil {
+		m.kv, err = js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:  env.EnsurePrefixed("cydian_maintenance"),
+			Storage: jetstream.FileStorage,
+		})
+	}
+	if err != nil {
+		logger.Warn("maintenance state won't survive restarts, JetStream is unavailable", "err", err)
+		m.kv = nil
+		return
+	}
+
+	entry, err := m.kv.Get(ctx, stateKey)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return
+	}
+	if err != nil {
+		logger.Error("failed to read the persisted maintenance state", "err", err)
+		return
+	}
+	var state MaintenanceState
+	if err := json.Unmarshal(entry.Value(), &state); err != nil {
+		logger.Error("failed to decode the persisted maintenance state", "err", err)
+		return
+	}
+	if state.ScaledDown == nil {
+		state.ScaledDown = make(map[string]int)
+	}
+	state.Environment = m.state.Environment
+	m.state = state
+	logger.Info("restored maintenance state", "enabled", state.Enabled, "scaled_down", state.ScaledDown)
+}
+
+// Persistence describes the maintenance bucket, failing if it can't be reached
+func (m *Maintenance) Persistence(ctx context.Context) (string, error) {
+	if m.kv == nil {
+		return "in-memory only, JetStream is unavailable", nil
+	}
+	status, err := m.kv.Status(ctx)
+	if err != nil {
+		return "", fmt.Errorf("maintenance bucket: %w", err)
+	}
+	return fmt.Sprintf("bucket %s, %d bytes", status.Bucket(), status.Bytes()), nil
+}
+
+// persist writes the state to the maintenance bucket. m.mu must not be held.
+func (m *Maintenance) persist(ctx context.Context) {
+	if m.kv == nil {
+		return
+	}
+	data, err := json.Marshal(m.Get())
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal maintenance state", "err", err)
+		return
+	}
+	if _, err := m.kv.Put(ctx, stateKey, data); err != nil {
+		logger.ErrorContext(ctx, "failed to persist maintenance state", "err", err)
+	}
+}
+
+// Set enables or disables maintenance. When enabling, the requested server types are scaled to zero and
+// their previous counts are restored once maintenance is disabled again. Types that fail to scale back up
+// keep their count and fail the request with ERR_RESTORE_FAILED, disabling again retries them.
+func (m *Maintenance) Set(ctx context.Context, req MaintenanceSetRequest) (MaintenanceState, string) {
+	ctx, span := tracing.Start(ctx, "Maintenance.Set")
+	defer span.End()
+	m.setMu.Lock()
+	defer m.setMu.Unlock()
+
+	m.mu.Lock()
+	// disabling again retries restoring the server types that failed to scale back up
+	if m.state.Enabled == req.Enabled && (req.Enabled || len(m.state.ScaledDown) == 0) {
+		defer m.mu.Unlock()
+		return m.copyInternal(), "ERR_ALREADY_STATE"
+	}
+	// switch first, so players are refused while the servers scale down
+	m.state.Enabled = req.Enabled
+	m.state.Message = req.Message
+	m.state.Since = nil
+	if req.Enabled {
+		now := time.Now()
+		m.state.Since = &now
+	}
+	restore := maps.Clone(m.state.ScaledDown)
+	m.mu.Unlock()
+	m.persist(ctx)
+
+	// the counts are persisted before scaling down, a restart in between must still restore them
+	var failed string
+	if req.Enabled {
+		for _, serverType := range req.ScaleDown {
+			count, errMsg := m.scaler.GroupCount(ctx, serverType)
+			if len(errMsg) > 0 {
+				logger.WarnContext(ctx, "not scaling down for maintenance", "type", serverType, "code", errMsg)
+				continue
+			}
+			if _, ok := restore[serverType]; ok {
+				// still waiting to be restored from an earlier maintenance, don't overwrite its count with zero
+				continue
+			}
+			m.setScaledDown(ctx, serverType, count, true)
+			if errMsg := m.scaler.ScaleTo(ctx, serverType, 0, "Network maintenance"); len(errMsg) > 0 {
+				m.setScaledDown(ctx, serverType, 0, false)
+			}
+		}
+		logger.InfoContext(ctx, "network maintenance enabled", "environment", m.state.Environment, "message", req.Message)
+	} else {
+		for serverType, count := range restore {
+			if errMsg := m.scaler.ScaleTo(ctx, serverType, count, "Network maintenance ended"); len(errMsg) > 0 {
+				// keep the count, disabling again retries
+				failed = "ERR_RESTORE_FAILED"
+				continue
+			}
+			m.setScaledDown(ctx, serverType, 0, false)
+		}
+		logger.InfoContext(ctx, "network maintenance disabled", "environment", m.state.Environment)
+	}
+
+	state := m.Get()
+	data, err := json.Marshal(state)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal maintenance state", "err", err)
+		return state, ""
+	}
+	if err := utils.Publish(ctx, m.nc, env.EnsurePrefixed("network.maintenance.notify"), data); err != nil {
+		logger.ErrorContext(ctx, "failed to publish maintenance notification", "err", err)
+		return state, "ERR_BROADCAST_FAILED"
+	}
+	return state, failed
+}
+
+// setScaledDown records (or forgets) the count to restore for a server type, then persists the state
+func (m *Maintenance) setScaledDown(ctx context.Context, serverType string, count int, scaled bool) {
+	m.mu.Lock()
+	if scaled {
+		m.state.ScaledDown[serverType] = count
+	} else {
+		delete(m.state.ScaledDown, serverType)
+	}
+	m.mu.Unlock()
+	m.persist(ctx)
+}
+
+// Get returns the current maintenance state
+func (m *Maintenance) Get() MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.copyInternal()
+}
+
+// Admit reports whether a player may be routed onto the network
+func (m *Maintenance) Admit(staff bool) (bool, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state.Enabled && !staff {
+		return false, "ERR_MAINTENANCE"
+	}
+	return true, ""
+}
+
+func (m *Maintenance) copyInternal() MaintenanceState {
+	state := m.state
+	state.ScaledDown = make(map[string]int, len(m.state.ScaledDown))
+	for k, v := range m.state.ScaledDown {
+		state.ScaledDown[k] = v
+	}
+	return state
+}
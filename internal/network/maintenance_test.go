@@ -0,0 +1,119 @@
+package network
+
+import (
+	"context"
+	"io"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// fakeScaler keeps instance counts in memory, failing to scale the types in failing
+type fakeScaler struct {
+	counts  map[string]int
+	failing map[string]bool
+}
+
+func (s *fakeScaler) GroupCount(_ context.Context, serverType string) (int, string) {
+	count, ok := s.counts[serverType]
+	if !ok {
+		return 0, "JOB_NOT_FOUND"
+	}
+	return count, ""
+}
+
+func (s *fakeScaler) ScaleTo(_ context.Context, serverType string, count int, _ string) string {
+	if s.failing[serverType] {
+		return "ERR_SCALE_FAILED"
+	}
+	s.counts[serverType] = count
+	return ""
+}
+
+// newTestConn connects to an in-process NATS server with JetStream, so the state is persisted
+func newTestConn(t *testing.T) *nats.Conn {
+	t.Helper()
+	if err := logging.Setup(io.Discard, "text", "error"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer(&server.Options{
+		Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true, JetStream: true, StoreDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		nc.Close()
+		s.Shutdown()
+	})
+	return nc
+}
+
+func TestMaintenanceRoundTrip(t *testing.T) {
+	nc := newTestConn(t)
+	scaler := &fakeScaler{counts: map[string]int{"lobby": 4, "minigames": 2, "proxy": 3}, failing: map[string]bool{}}
+	m := newMaintenance(nc, scaler)
+	ctx := context.Background()
+
+	state, code := m.Set(ctx, MaintenanceSetRequest{Enabled: true, Message: "Updating", ScaleDown: []string{"lobby", "minigames", "missing"}})
+	if code != "" || !state.Enabled || state.Since == nil {
+		t.Fatalf("enabling: %q, enabled=%v", code, state.Enabled)
+	}
+	if want := map[string]int{"lobby": 4, "minigames": 2}; !maps.Equal(state.ScaledDown, want) {
+		t.Fatalf("scaled down %v, want %v", state.ScaledDown, want)
+	}
+	if scaler.counts["lobby"] != 0 || scaler.counts["minigames"] != 0 || scaler.counts["proxy"] != 3 {
+		t.Fatalf("only the requested types should be scaled to zero, got %v", scaler.counts)
+	}
+	if ok, code := m.Admit(false); ok || code != "ERR_MAINTENANCE" {
+		t.Fatalf("players should be refused, got %v %q", ok, code)
+	}
+	if ok, _ := m.Admit(true); !ok {
+		t.Fatal("staff should be admitted")
+	}
+	if _, code := m.Set(ctx, MaintenanceSetRequest{Enabled: true, ScaleDown: []string{"lobby"}}); code != "ERR_ALREADY_STATE" {
+		t.Fatalf("enabling again: got %q, want ERR_ALREADY_STATE", code)
+	}
+
+	// a type failing to scale back up keeps its count
+	scaler.failing["minigames"] = true
+	state, code = m.Set(ctx, MaintenanceSetRequest{Enabled: false})
+	if code != "ERR_RESTORE_FAILED" || state.Enabled {
+		t.Fatalf("disabling: got %q, enabled=%v, want ERR_RESTORE_FAILED", code, state.Enabled)
+	}
+	if want := map[string]int{"minigames": 2}; !maps.Equal(state.ScaledDown, want) {
+		t.Fatalf("left to restore %v, want %v", state.ScaledDown, want)
+	}
+	if scaler.counts["lobby"] != 4 {
+		t.Fatalf("lobby should be restored to 4, got %d", scaler.counts["lobby"])
+	}
+	if ok, _ := m.Admit(false); !ok {
+		t.Fatal("players should be admitted once disabled")
+	}
+
+	// the count to restore survives a restart, and disabling again retries it
+	restarted := newMaintenance(nc, scaler)
+	if state := restarted.Get(); state.Enabled || !maps.Equal(state.ScaledDown, map[string]int{"minigames": 2}) {
+		t.Fatalf("restored enabled=%v with %v to restore", state.Enabled, state.ScaledDown)
+	}
+	scaler.failing["minigames"] = false
+	state, code = restarted.Set(ctx, MaintenanceSetRequest{Enabled: false})
+	if code != "" || len(state.ScaledDown) != 0 || scaler.counts["minigames"] != 2 {
+		t.Fatalf("retrying the restore: got %q with %v left, minigames at %d", code, state.ScaledDown, scaler.counts["minigames"])
+	}
+	if _, code := restarted.Set(ctx, MaintenanceSetRequest{Enabled: false}); code != "ERR_ALREADY_STATE" {
+		t.Fatalf("disabling again: got %q, want ERR_ALREADY_STATE", code)
+	}
+}
@@ -0,0 +1,41 @@
+package network
+
+import (
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/google/uuid"
+)
+
+// MaintenanceState is the network-wide maintenance switch of this environment
+type MaintenanceState struct {
+	Enabled     bool           `json:"enabled"`
+	Message     string         `json:"message"`
+	Environment string         `json:"environment"`
+	Since       *time.Time     `json:"since"`       // nil while disabled
+	ScaledDown  map[string]int `json:"scaled_down"` // server type -> instance count to restore once disabled
+}
+
+type MaintenanceSetRequest struct {
+	Enabled   bool     `json:"enabled"`
+	Message   string   `json:"message"`    // shown by proxies as the kick message / MOTD
+	ScaleDown []string `json:"scale_down"` // non-essential server types to scale to zero while enabled
+}
+
+type MaintenanceResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	State   MaintenanceState `json:"state"`
+}
+
+type ServerSelectRequest struct {
+	Type     string    `json:"type"`
+	PlayerID uuid.UUID `json:"player_id"`
+	Staff    bool      `json:"staff"` // staff bypass network maintenance
+}
+
+type ServerSelectResponse struct {
+	Success bool                `json:"success"`
+	Message string              `json:"message"`
+	Server  *servers.ServerInfo `json:"server"`
+}
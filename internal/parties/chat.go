@@ -0,0 +1,127 @@
+package parties
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const maxChatMessage = 256
+
+// ServerLookup returns the ID of the server the player is on, false when it isn't known
+type ServerLookup func(player UUID) (server string, ok bool)
+
+// ChatRelay delivers party chat, so mutes and rate limits are enforced the same way on every server
+type ChatRelay struct {
+	mu       sync.Mutex
+	registry *PartyRegistry
+	servers  ServerLookup
+	buckets  map[UUID]*chatBucket
+	nc       *nats.Conn
+}
+
+// chatBucket is a token bucket, refilled at ChatRateLimit tokens per ChatRateWindow
+type chatBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewChatRelay(nc *nats.Conn, registry *PartyRegistry, servers ServerLookup) *ChatRelay {
+	return &ChatRelay{
+		registry: registry,
+		servers:  servers,
+		buckets:  make(map[UUID]*chatBucket),
+		nc:       nc,
+	}
+}
+
+// Send relays the message to the party of the sender. Each server hosting members gets one notify on
+// party.chat.notify.<server id>, naming the recipients on it. Members on unknown servers are skipped.
+func (c *ChatRelay) Send(ctx context.Context, sender UUID, message string) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "ChatRelay.Send", attribute.Stringer("cydian.player_id", sender))
+	defer span.End()
+
+	message = strings.TrimSpace(message)
+	if message == "" || utf8.RuneCountInString(message) > maxChatMessage {
+		return false, "ERR_INVALID_MESSAGE"
+	}
+	_, party := c.registry.GetPlayerParty(sender)
+	if party == nil {
+		return false, "ERR_NOT_IN_PARTY"
+	}
+	if party.Muted && party.CurrentLeader != sender && !party.IsModerator(sender) {
+		return false, "ERR_PARTY_MUTED"
+	}
+	if party.MutedMembers.Contains(sender) {
+		return false, "ERR_MUTED"
+	}
+	if !c.allow(sender, time.Now()) {
+		return false, "ERR_RATE_LIMITED"
+	}
+
+	recipients := make(map[string][]UUID)
+	members := append(append(party.Members.Slice(), party.Moderators.Slice()...), party.CurrentLeader)
+	for _, member := range members {
+		if server, ok := c.servers(member); ok {
+			recipients[server] = append(recipients[server], member)
+		}
+	}
+	now := time.Now().UTC()
+	for server, players := range recipients {
+		msg, _ := json.Marshal(&PartyChatNotifyPacket{
+			PartyID:    party.ID,
+			SenderID:   sender,
+			Message:    message,
+			Recipients: players,
+			Time:       now,
+		})
+		if err := utils.Publish(ctx, c.nc, env.EnsurePrefixed("party.chat.notify."+server), msg); err != nil {
+			logger.ErrorContext(ctx, "failed to relay party chat", "party", party.ID, "server", server, "err", err)
+		}
+	}
+	return true, ""
+}
+
+// allow takes a token from the player's bucket, if one is left
+func (c *ChatRelay) allow(player UUID, now time.Time) bool {
+	cfg := config.Get().Parties
+	limit := float64(cfg.ChatRateLimit)
+	window := cfg.ChatRateWindow.D()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bucket, ok := c.buckets[player]
+	if !ok {
+		if len(c.buckets) >= 1024 {
+			c.pruneInternal(now, window)
+		}
+		bucket = &chatBucket{tokens: limit, last: now}
+		c.buckets[player] = bucket
+	}
+	bucket.tokens = min(limit, bucket.tokens+now.Sub(bucket.last).Seconds()*limit/window.Seconds())
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// pruneInternal forgets buckets that have refilled, they are no different from new ones
+func (c *ChatRelay) pruneInternal(now time.Time, window time.Duration) {
+	for player, bucket := range c.buckets {
+		if now.Sub(bucket.last) >= window {
+			delete(c.buckets, player)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package parties
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const eventQueueSize = 4096
+
+type queuedEvent struct {
+	event PartyEvent
+	data  []byte
+}
+
+// EventStream publishes party events to a JetStream stream, on <prefix>party.events.<type>. Events are
+// published one at a time by a single goroutine, so the stream keeps them in sequence order.
+type EventStream struct {
+	js      jetstream.JetStream
+	subject string
+	queue   chan queuedEvent
+	done    chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+	closed  bool
+}
+
+// NewEventStream creates or updates the stream and returns the sequence of the last event in it,
+// so numbering continues where the previous run stopped
+func NewEventStream(nc *nats.Conn, stream string, maxAge time.Duration) (*EventStream, uint64, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, 0, err
+	}
+	s := &EventStream{
+		js:      js,
+		subject: env.EnsurePrefixed("party.events"),
+		queue:   make(chan queuedEvent, eventQueueSize),
+		done:    make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	str, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       env.EnsurePrefixed(stream),
+		Subjects:   []string{s.subject + ".>"},
+		Storage:    jetstream.FileStorage,
+		MaxAge:     maxAge,
+		Duplicates: time.Minute, // retried publishes carry the sequence as their message id
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating party event stream: %w", err)
+	}
+
+	var last uint64
+	info, err := str.Info(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.State.Msgs > 0 {
+		msg, err := str.GetMsg(ctx, info.State.LastSeq)
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading the last party event: %w", err)
+		}
+		var event PartyEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			return nil, 0, fmt.Errorf("decoding the last party event: %w", err)
+		}
+		last = event.Sequence
+	}
+
+	go s.run()
+	return s, last, nil
+}
+
+// Append queues the event. When the queue is full the event is dropped, consumers notice the gap in sequences.
+func (s *EventStream) Append(event PartyEvent, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- queuedEvent{event: event, data: data}:
+	default:
+		logger.Error("party event queue is full, dropping event", "sequence", event.Sequence, "type", event.Type)
+		s.lastErr = fmt.Errorf("dropped event %d, the queue is full", event.Sequence)
+	}
+}
+
+func (s *EventStream) run() {
+	defer close(s.done)
+	for queued := range s.queue {
+		msg := nats.NewMsg(s.subject + "." + string(queued.event.Type))
+		msg.Data = queued.data
+		msg.Header.Set(jetstream.MsgIDHeader, strconv.FormatUint(queued.event.Sequence, 10))
+
+		var err error
+		for attempt := range 3 {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err = s.js.PublishMsg(ctx, msg)
+			cancel()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Error("failed to publish party event", "sequence", queued.event.Sequence, "type", queued.event.Type, "err", err)
+		}
+		s.setErr(err)
+	}
+}
+
+func (s *EventStream) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+}
+
+// Err returns the error of the last publish, nil once an event has been stored again
+func (s *EventStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Pending returns how many events are waiting to be published
+func (s *EventStream) Pending() int {
+	return len(s.queue)
+}
+
+// Close publishes the queued events, giving up when ctx is done. Later events are discarded.
+func (s *EventStream) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d party events were not published: %w", len(s.queue), ctx.Err())
+	}
+}
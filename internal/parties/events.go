@@ -0,0 +1,215 @@
+package parties
+
+import (
+	"time"
+)
+
+// EventType names a party mutation. Every change the registry makes is emitted as one of these, in order,
+// so the full party state can be rebuilt with Replay.
+type EventType string
+
+const (
+	// EventReset is emitted once when Cydian starts. State held in memory was lost, so every party
+	// derived from earlier events must be dropped.
+	EventReset            EventType = "reset"
+	EventCreated          EventType = "created"       // Party holds the initial state
+	EventDisbanded        EventType = "disbanded"     // Reason is command, forced or empty
+	EventMemberJoined     EventType = "member_joined" // Player joined as a member, Reason is their JoinSource
+	EventMemberLeft       EventType = "member_left"   // Reason is left or disconnected
+	EventMemberKicked     EventType = "member_kicked" // Actor kicked Player, who can't rejoin before Until
+	EventPlayerBanned     EventType = "player_banned" // Actor banned Player, removing them if they were in the party
+	EventPlayerUnbanned   EventType = "player_unbanned"
+	EventMemberMuted      EventType = "member_muted"      // State is false when the mute was lifted
+	EventMemberOffline    EventType = "member_offline"    // Player disconnected, and is removed at Until
+	EventMemberOnline     EventType = "member_online"     // Player reconnected before being removed
+	EventModeratorAdded   EventType = "moderator_added"   // Player was promoted from member
+	EventModeratorRemoved EventType = "moderator_removed" // Player was demoted to member
+	// EventLeaderChanged makes Player the leader. The previous leader takes Role, member or moderator,
+	// or leaves the party when Role is empty. Reason offline means the previous leader disconnected,
+	// and becomes the party's DisplacedLeader.
+	EventLeaderChanged  EventType = "leader_changed"
+	EventSettingChanged EventType = "setting_changed" // Setting is muted, open or open_invites
+	EventListingChanged EventType = "listing_changed" // Listing holds the new party finder listing
+	EventInviteAdded    EventType = "invite_added"
+	EventInviteRemoved  EventType = "invite_removed" // Reason is accepted, expired, declined, revoked or failed
+	// EventSuccessionChanged sets the party's succession policy to Policy
+	EventSuccessionChanged EventType = "succession_changed"
+)
+
+// Party roles and settings carried by events
+const (
+	RoleLeader    = "leader"
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+
+	SettingMuted       = "muted"
+	SettingOpen        = "open"
+	SettingOpenInvites = "open_invites"
+)
+
+// PartyEvent is a single, sequence-numbered party mutation. Sequences are assigned by Cydian, increase
+// by one per event and are unique for the lifetime of the event stream, so a gap means events were missed.
+type PartyEvent struct {
+	Sequence uint64        `json:"sequence"`
+	Type     EventType     `json:"type"`
+	Time     time.Time     `json:"time"`
+	PartyID  UUID          `json:"party_id"`
+	Player   *UUID         `json:"player,omitempty"` // the player the event is about
+	Actor    *UUID         `json:"actor,omitempty"`  // who caused it, absent when Cydian did
+	Reason   string        `json:"reason,omitempty"`
+	Role     string        `json:"role,omitempty"`
+	Setting  string        `json:"setting,omitempty"`
+	State    *bool         `json:"state,omitempty"`
+	Party    *Party        `json:"party,omitempty"`
+	Invite   *PartyInvite  `json:"invite,omitempty"`
+	Listing  *PartyListing `json:"listing,omitempty"`
+	Until    *time.Time    `json:"until,omitempty"`
+	Policy   string        `json:"policy,omitempty"`
+}
+
+// Apply folds the event into parties. Events about unknown parties are ignored.
+func Apply(parties map[UUID]Party, event PartyEvent) {
+	if event.Type == EventReset {
+		clear(parties)
+		return
+	}
+	if event.Type == EventCreated {
+		if event.Party != nil {
+			party := *event.Party
+			party.Version = event.Sequence
+			if party.ActiveInvites == nil {
+				party.ActiveInvites = make(map[UUID]PartyInvite)
+			}
+			if party.Bans == nil {
+				party.Bans = NewSet()
+			}
+			if party.MutedMembers == nil {
+				party.MutedMembers = NewSet()
+			}
+			parties[party.ID] = party
+		}
+		return
+	}
+
+	party, ok := parties[event.PartyID]
+	if !ok {
+		return
+	}
+	var player UUID
+	if event.Player != nil {
+		player = *event.Player
+	}
+
+	party.Version = event.Sequence
+	switch event.Type {
+	case EventDisbanded:
+		delete(parties, event.PartyID)
+		return
+	case EventMemberJoined:
+		party.Members.Add(player)
+		party.setJoined(player, event.Time)
+	case EventMemberLeft:
+		party.Members.Remove(player)
+		party.Moderators.Remove(player)
+		party.drop(player)
+	case EventMemberKicked:
+		party.Members.Remove(player)
+		party.Moderators.Remove(player)
+		party.drop(player)
+		if event.Until != nil {
+			if party.KickCooldowns == nil {
+				party.KickCooldowns = make(map[UUID]time.Time)
+			}
+			party.pruneCooldowns(event.Time)
+			party.KickCooldowns[player] = *event.Until
+		}
+	case EventPlayerBanned:
+		party.Members.Remove(player)
+		party.Moderators.Remove(player)
+		party.Bans.Add(player)
+		delete(party.KickCooldowns, player)
+		party.drop(player)
+	case EventPlayerUnbanned:
+		party.Bans.Remove(player)
+	case EventMemberMuted:
+		if event.State != nil && *event.State {
+			party.MutedMembers.Add(player)
+		} else {
+			party.MutedMembers.Remove(player)
+		}
+	case EventMemberOffline:
+		if event.Until != nil {
+			party.setOffline(player, *event.Until)
+		}
+	case EventMemberOnline:
+		party.forget(player)
+	case EventModeratorAdded:
+		party.Members.Remove(player)
+		party.Moderators.Add(player)
+	case EventModeratorRemoved:
+		party.Moderators.Remove(player)
+		party.Members.Add(player)
+	case EventLeaderChanged:
+		previous := party.CurrentLeader
+		party.CurrentLeader = player
+		party.Members.Remove(player)
+		party.Moderators.Remove(player)
+		switch event.Role {
+		case RoleMember:
+			party.Members.Add(previous)
+		case RoleModerator:
+			party.Moderators.Add(previous)
+		default:
+			party.drop(previous)
+		}
+		if event.Reason == "offline" {
+			party.DisplacedLeader = &previous
+		}
+	case EventSettingChanged:
+		if event.State == nil {
+			break
+		}
+		switch event.Setting {
+		case SettingMuted:
+			party.Muted = *event.State
+		case SettingOpen:
+			party.Open = *event.State
+		case SettingOpenInvites:
+			party.OpenInvites = *event.State
+		}
+	case EventListingChanged:
+		if event.Listing != nil {
+			party.Listing = *event.Listing
+		}
+	case EventSuccessionChanged:
+		party.Succession = event.Policy
+	case EventInviteAdded:
+		if event.Invite != nil {
+			party.ActiveInvites[event.Invite.ID] = *event.Invite
+		}
+	case EventInviteRemoved:
+		if event.Invite != nil {
+			delete(party.ActiveInvites, event.Invite.ID)
+		}
+	}
+	parties[event.PartyID] = party
+}
+
+// Replay rebuilds party state from events in sequence order
+func Replay(events []PartyEvent) map[UUID]Party {
+	parties := make(map[UUID]Party)
+	for _, event := range events {
+		Apply(parties, event)
+	}
+	return parties
+}
+
+// EventLog receives every event the registry emits, already encoded. Append is called with the registry
+// locked, in sequence order, and must not block.
+type EventLog interface {
+	Append(event PartyEvent, data []byte)
+}
+
+type noEventLog struct{}
+
+func (noEventLog) Append(PartyEvent, []byte) {}
@@ -0,0 +1,47 @@
+package parties
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// expiries holds the timers of pending invites, join requests and disconnects, by their ID. It is guarded
+// by the lock of the registry that owns it, which expire must take itself.
+type expiries map[UUID]expiry
+
+// expiry is a pending timer. Its generation tells it apart from other timers of the same ID.
+type expiry struct {
+	timer      *time.Timer
+	generation uint64
+}
+
+var generations atomic.Uint64
+
+// schedule calls expire once the duration has passed, unless the ID is cancelled first
+func (e expiries) schedule(id UUID, after time.Duration, expire func()) {
+	e.scheduleGeneration(id, after, func(uint64) { expire() })
+}
+
+// scheduleGeneration is schedule for IDs that come back, like players. expire is given the timer's
+// generation to check with current, as a timer may fire just as its ID is cancelled and scheduled again.
+func (e expiries) scheduleGeneration(id UUID, after time.Duration, expire func(generation uint64)) {
+	generation := generations.Add(1)
+	e[id] = expiry{
+		timer:      time.AfterFunc(after, func() { expire(generation) }),
+		generation: generation,
+	}
+}
+
+// current reports whether the generation is still the ID's pending timer
+func (e expiries) current(id UUID, generation uint64) bool {
+	pending, ok := e[id]
+	return ok && pending.generation == generation
+}
+
+// cancel stops the timer of the ID, if it has one
+func (e expiries) cancel(id UUID) {
+	if pending, ok := e[id]; ok {
+		pending.timer.Stop()
+		delete(e, id)
+	}
+}
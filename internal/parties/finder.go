@@ -0,0 +1,107 @@
+package parties
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	maxListingTitle    = 32
+	maxListingTag      = 24
+	maxListingLanguage = 8
+)
+
+// SetListing replaces how the party shows up in the party finder. Only the leader may change it.
+func (r *PartyRegistry) SetListing(ctx context.Context, sender UUID, partyID UUID, listing PartyListing) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.SetListing",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.listing", sender.String(), partyID, UUID(uuid.Nil), audit.Result(success, error),
+			map[string]any{"listed": listing.Listed, "title": listing.Title, "tag": listing.Tag, "language": listing.Language})
+	}()
+
+	listing.Title = strings.TrimSpace(listing.Title)
+	listing.Tag = strings.ToLower(strings.TrimSpace(listing.Tag))
+	listing.Language = strings.ToLower(strings.TrimSpace(listing.Language))
+	if utf8.RuneCountInString(listing.Title) > maxListingTitle || len(listing.Tag) > maxListingTag || len(listing.Language) > maxListingLanguage {
+		return false, "ERR_INVALID_LISTING"
+	}
+
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		return false, "ERR_INVALID_PARTY"
+	}
+	defer entry.mu.Unlock()
+	party := &entry.party
+	if party.CurrentLeader != sender {
+		return false, "ERR_NO_PERMISSION"
+	}
+	if party.Listing == listing {
+		return false, "ERR_ALREADY_STATE"
+	}
+
+	party.Listing = listing
+	r.emit(ctx, entry, PartyEvent{Type: EventListingChanged, PartyID: partyID, Actor: ref(sender), Listing: &listing})
+
+	msg, _ := json.Marshal(&PartyListingPacket{
+		PartyID:  partyID,
+		PlayerID: sender,
+		Listing:  listing,
+	})
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.finder.update.notify"), msg)
+	return true, ""
+}
+
+// Find returns the open, listed parties that still have room and match the request, largest first
+func (r *PartyRegistry) Find(ctx context.Context, request PartyFinderRequest) []PartyFinderEntry {
+	tag := strings.ToLower(strings.TrimSpace(request.Tag))
+	language := strings.ToLower(strings.TrimSpace(request.Language))
+
+	r.mu.Lock()
+	entries := r.entriesInternal()
+	r.mu.Unlock()
+
+	found := make([]PartyFinderEntry, 0)
+	for _, entry := range entries {
+		entry.mu.Lock()
+		party := entry.party
+		if entry.removed || !party.Open || !party.Listing.Listed ||
+			(tag != "" && party.Listing.Tag != tag) || (language != "" && party.Listing.Language != language) {
+			entry.mu.Unlock()
+			continue
+		}
+		capacity := r.capacity(ctx, entry)
+		if party.Occupancy() < capacity {
+			found = append(found, PartyFinderEntry{
+				PartyID:  party.ID,
+				Title:    party.Listing.Title,
+				Tag:      party.Listing.Tag,
+				Language: party.Listing.Language,
+				Leader:   party.CurrentLeader,
+				Size:     party.TotalSize(),
+				MaxSize:  capacity,
+			})
+		}
+		entry.mu.Unlock()
+	}
+
+	slices.SortFunc(found, func(a, b PartyFinderEntry) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		return strings.Compare(a.PartyID.String(), b.PartyID.String())
+	})
+	return found
+}
@@ -1,15 +1,19 @@
 package parties
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"sync"
 	"time"
 
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/config"
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // InviteRegistry to store active servers
@@ -17,22 +21,31 @@ type InviteRegistry struct {
 	mu sync.Mutex
 	// keyed by REQUEST uuid
 	invites         map[UUID]PartyInvite
-	expiryFunctions map[UUID]*time.Timer
+	expiryFunctions expiries
 	partyRegistry   *PartyRegistry
 	nc              *nats.Conn
 }
 
 // NewInviteRegistry creates a new Registry instance
 func NewInviteRegistry(conn *nats.Conn, registry *PartyRegistry) *InviteRegistry {
-	return &InviteRegistry{
+	r := &InviteRegistry{
 		invites:         make(map[UUID]PartyInvite),
-		expiryFunctions: make(map[UUID]*time.Timer),
+		expiryFunctions: make(expiries),
 		nc:              conn,
 		partyRegistry:   registry,
 	}
+	registry.OnBan(r.revokeBanned)
+	return r
 }
 
-func (r *InviteRegistry) CreateInvite(sender UUID, party UUID, recipient UUID) (*PartyInvite, string) {
+// CreateInvite invites the recipient to the party. senderRank is optional, see PartyInviteSendPacket.
+func (r *InviteRegistry) CreateInvite(ctx context.Context, sender UUID, party UUID, recipient UUID, senderRank string) (sent *PartyInvite, error string) {
+	ctx, span := tracing.Start(ctx, "InviteRegistry.CreateInvite",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", party), attribute.Stringer("cydian.recipient_id", recipient))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.invite", sender.String(), party, recipient, audit.Result(sent != nil, error), nil)
+	}()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -41,65 +54,240 @@ func (r *InviteRegistry) CreateInvite(sender UUID, party UUID, recipient UUID) (
 		if partyObj.IsInParty(recipient) {
 			return nil, "ERR_ALREADY_IN_PARTY"
 		}
+		if code := partyObj.JoinBlocked(recipient, time.Now()); code != "" {
+			return nil, code
+		}
 		if !partyObj.OpenInvites && partyObj.CurrentLeader != sender {
 			return nil, "ERR_NO_PERMISSION"
 		}
 	}
 	if r.containsPairInternal(party, recipient) {
-		fmt.Printf("Party Invite registry already contains an invite to %s, for the party: %s", recipient, party)
+		logger.DebugContext(ctx, "player already invited to party", "party", party, "recipient", recipient)
 		return nil, "ERR_ALREADY_INVITED"
 	}
 
+	expiry := config.Get().Parties.InviteExpiry.D()
 	inviteUUID := UUID(uuid.New())
+	now := time.Now()
 	invite := PartyInvite{
 		ID:        inviteUUID,
 		PartyID:   party,
 		Recipient: recipient,
 		SenderID:  sender,
-		Expiry:    time.Now().Add(time.Second * 60),
+		SentAt:    now,
+		Expiry:    now.Add(expiry),
 	}
 
-	// they expire after 60 seconds
-	r.expiryFunctions[inviteUUID] = time.AfterFunc(time.Second*60, func() {
-		r.expireInvite(inviteUUID)
-	})
+	if code := r.partyRegistry.TrackInvite(ctx, party, invite, senderRank); code != "" {
+		return nil, code
+	}
 
+	// the request's span has ended by the time the invite expires
+	detached := context.WithoutCancel(ctx)
+	r.expiryFunctions.schedule(inviteUUID, expiry, func() {
+		r.expireInvite(detached, inviteUUID)
+	})
 	r.invites[inviteUUID] = invite
-	r.partyRegistry.TrackInvite(party, invite)
 
-	log.Printf("Party (%+v) invite sent: %+v", party, invite)
+	logger.InfoContext(ctx, "party invite sent", "party", party, "invite", inviteUUID, "sender", sender, "recipient", recipient)
 	return &invite, ""
 }
 
-// Accept Accepts the request with the specified ID.
-func (r *InviteRegistry) Accept(id UUID) (bool, *PartyInvite) {
+// Accept accepts the invite with the specified ID. On failure, the code says why the recipient couldn't
+// join, ie: ERR_PARTY_FULL.
+func (r *InviteRegistry) Accept(ctx context.Context, id UUID) (*PartyInvite, string) {
+	ctx, span := tracing.Start(ctx, "InviteRegistry.Accept", attribute.Stringer("cydian.invite_id", id))
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if !r.containsKeyInternal(id) {
-		log.Printf("Attempted to accept invalid invite: %+v", id)
-		return false, nil
+		logger.DebugContext(ctx, "attempted to accept an unknown party invite", "invite", id)
+		return nil, "ERR_INVALID_INVITE"
 	}
+	return r.acceptInternal(ctx, id)
+}
 
-	req := r.invites[id]
-	delete(r.invites, id)
-	r.expiryFunctions[id].Stop()
-	delete(r.expiryFunctions, id)
+// AcceptFromSender accepts the recipient's invite sent by the player, or from the party they lead
+func (r *InviteRegistry) AcceptFromSender(ctx context.Context, recipient UUID, sender UUID) (*PartyInvite, string) {
+	ctx, span := tracing.Start(ctx, "InviteRegistry.AcceptFromSender",
+		attribute.Stringer("cydian.recipient_id", recipient), attribute.Stringer("cydian.sender_id", sender))
+	defer span.End()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matches []PartyInvite
+	for _, invite := range r.invites {
+		if invite.Recipient != recipient {
+			continue
+		}
+		if invite.SenderID == sender {
+			matches = append(matches, invite)
+			continue
+		}
+		if party := r.partyRegistry.GetParty(invite.PartyID); party != nil && party.CurrentLeader == sender {
+			matches = append(matches, invite)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, "ERR_INVALID_INVITE"
+	case 1:
+		return r.acceptInternal(ctx, matches[0].ID)
+	default:
+		logger.DebugContext(ctx, "ambiguous party invite acceptance", "recipient", recipient, "sender", sender, "matches", len(matches))
+		return nil, "ERR_MULTIPLE_INVITES"
+	}
+}
+
+// AcceptLatest accepts the most recent invite the recipient received
+func (r *InviteRegistry) AcceptLatest(ctx context.Context, recipient UUID) (*PartyInvite, string) {
+	ctx, span := tracing.Start(ctx, "InviteRegistry.AcceptLatest", attribute.Stringer("cydian.recipient_id", recipient))
+	defer span.End()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var latest *PartyInvite
+	for _, invite := range r.invites {
+		// not by expiry, a reload may have changed how long invites last
+		if invite.Recipient == recipient && (latest == nil || invite.SentAt.After(latest.SentAt)) {
+			latest = &invite
+		}
+	}
+	if latest == nil {
+		return nil, "ERR_INVALID_INVITE"
+	}
+	return r.acceptInternal(ctx, latest.ID)
+}
+
+// acceptInternal removes the invite and joins its recipient to the party
+func (r *InviteRegistry) acceptInternal(ctx context.Context, id UUID) (*PartyInvite, string) {
+	req := r.takeInternal(id)
 
 	if !r.partyRegistry.containsKey(req.PartyID) {
-		log.Printf("Attempted to accept an invite(%v) to a non-existant party: %+v", id, req.PartyID)
-		return false, nil
+		logger.WarnContext(ctx, "attempted to accept a party invite to a party that no longer exists", "invite", id, "party", req.PartyID)
+		record(ctx, "party.invite.accept", req.Recipient.String(), req.PartyID, req.SenderID, "ERR_INVALID_PARTY", nil)
+		return nil, "ERR_INVALID_PARTY"
 	}
 
-	success, err := r.partyRegistry.JoinParty(req.PartyID, req.Recipient, true)
+	success, err := r.partyRegistry.JoinParty(ctx, req.PartyID, req.Recipient, JoinInvite)
 	if !success {
-		log.Printf("Failed to join party after accepting invite: %s", err)
-		return false, nil
+		// the invite is gone from here, so it mustn't keep holding a place in the party either
+		r.partyRegistry.RemoveInvite(ctx, req.PartyID, id, "failed")
+		logger.WarnContext(ctx, "failed to join party after accepting invite", "invite", id, "party", req.PartyID, "code", err)
+		record(ctx, "party.invite.accept", req.Recipient.String(), req.PartyID, req.SenderID, audit.Result(false, err), nil)
+		return nil, audit.Result(false, err)
 	}
+	record(ctx, "party.invite.accept", req.Recipient.String(), req.PartyID, req.SenderID, audit.ResultSuccess, nil)
 
-	log.Printf("Party invite(%+v) accepted", id)
-	return true, &req
+	logger.InfoContext(ctx, "party invite accepted", "invite", id, "party", req.PartyID, "recipient", req.Recipient)
+	return &req, ""
+}
 
+// Decline removes an invite on behalf of its recipient
+func (r *InviteRegistry) Decline(ctx context.Context, id UUID, recipient UUID) (declined *PartyInvite, error string) {
+	ctx, span := tracing.Start(ctx, "InviteRegistry.Decline",
+		attribute.Stringer("cydian.invite_id", id), attribute.Stringer("cydian.player_id", recipient))
+	defer span.End()
+	var partyID, sender UUID
+	defer func() {
+		record(ctx, "party.invite.decline", recipient.String(), partyID, sender, audit.Result(declined != nil, error), nil)
+	}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invite, ok := r.invites[id]
+	if !ok {
+		return nil, "ERR_INVALID_INVITE"
+	}
+	partyID, sender = invite.PartyID, invite.SenderID
+	if invite.Recipient != recipient {
+		return nil, "ERR_NOT_RECIPIENT"
+	}
+
+	r.takeInternal(id)
+	r.partyRegistry.RemoveInvite(ctx, invite.PartyID, id, "declined")
+	logger.InfoContext(ctx, "party invite declined", "invite", id, "party", invite.PartyID, "recipient", recipient)
+	return &invite, ""
+}
+
+// Revoke removes an invite on behalf of whoever sent it, or the leader of its party
+func (r *InviteRegistry) Revoke(ctx context.Context, id UUID, sender UUID) (revoked *PartyInvite, error string) {
+	ctx, span := tracing.Start(ctx, "InviteRegistry.Revoke",
+		attribute.Stringer("cydian.invite_id", id), attribute.Stringer("cydian.sender_id", sender))
+	defer span.End()
+	var partyID, recipient UUID
+	defer func() {
+		record(ctx, "party.invite.revoke", sender.String(), partyID, recipient, audit.Result(revoked != nil, error), nil)
+	}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invite, ok := r.invites[id]
+	if !ok {
+		return nil, "ERR_INVALID_INVITE"
+	}
+	partyID, recipient = invite.PartyID, invite.Recipient
+	if invite.SenderID != sender {
+		party := r.partyRegistry.GetParty(invite.PartyID)
+		if party == nil || party.CurrentLeader != sender {
+			return nil, "ERR_NO_PERMISSION"
+		}
+	}
+
+	r.takeInternal(id)
+	r.partyRegistry.RemoveInvite(ctx, invite.PartyID, id, "revoked")
+	logger.InfoContext(ctx, "party invite revoked", "invite", id, "party", invite.PartyID, "sender", sender)
+	return &invite, ""
+}
+
+// revokeBanned removes the invites a player banned from the party still had to it
+func (r *InviteRegistry) revokeBanned(ctx context.Context, partyID UUID, player UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, invite := range r.invites {
+		if invite.PartyID != partyID || invite.Recipient != player {
+			continue
+		}
+		r.takeInternal(id)
+		r.partyRegistry.RemoveInvite(ctx, partyID, id, "revoked")
+		record(ctx, "party.invite.revoke", audit.ActorSystem, partyID, player, audit.ResultSuccess, map[string]any{"reason": "banned"})
+		logger.InfoContext(ctx, "party invite revoked, recipient banned", "invite", id, "party", partyID, "recipient", player)
+	}
+}
+
+// Incoming returns the invites sent to the player
+func (r *InviteRegistry) Incoming(player UUID) []PartyInvite {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invites := make([]PartyInvite, 0)
+	for _, invite := range r.invites {
+		if invite.Recipient == player {
+			invites = append(invites, invite)
+		}
+	}
+	return invites
+}
+
+// Outgoing returns the invites sent by the player
+func (r *InviteRegistry) Outgoing(player UUID) []PartyInvite {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invites := make([]PartyInvite, 0)
+	for _, invite := range r.invites {
+		if invite.SenderID == player {
+			invites = append(invites, invite)
+		}
+	}
+	return invites
+}
+
+// takeInternal removes the invite and stops its expiry timer
+func (r *InviteRegistry) takeInternal(id UUID) PartyInvite {
+	invite := r.invites[id]
+	delete(r.invites, id)
+	r.expiryFunctions.cancel(id)
+	return invite
 }
 
 // GetAll returns all active invites
@@ -119,7 +307,9 @@ func (r *InviteRegistry) Get(id UUID) PartyInvite {
 	return r.invites[id]
 }
 
-func (r *InviteRegistry) expireInvite(requestUUID UUID) {
+func (r *InviteRegistry) expireInvite(ctx context.Context, requestUUID UUID) {
+	ctx, span := tracing.Start(ctx, "InviteRegistry.expireInvite", attribute.Stringer("cydian.invite_id", requestUUID))
+	defer span.End()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -127,10 +317,10 @@ func (r *InviteRegistry) expireInvite(requestUUID UUID) {
 		return
 	}
 
-	invite := r.invites[requestUUID]
-	delete(r.invites, requestUUID)
+	invite := r.takeInternal(requestUUID)
+	record(ctx, "party.invite.expire", audit.ActorSystem, invite.PartyID, invite.Recipient, audit.ResultSuccess, nil)
 
-	if !r.partyRegistry.RemoveInvite(invite.PartyID, invite.ID) {
+	if !r.partyRegistry.RemoveInvite(ctx, invite.PartyID, invite.ID, "expired") {
 		return // prevents sending notices if it was somehow already accepted
 	}
 
@@ -140,13 +330,15 @@ func (r *InviteRegistry) expireInvite(requestUUID UUID) {
 	}
 	serialized, err1 := json.Marshal(obj)
 	if err1 != nil {
-		log.Printf("Error marshalling invite (%v) expiry: %v", requestUUID, err1)
+		logger.ErrorContext(ctx, "failed to marshal party invite expiry", "invite", requestUUID, "err", err1)
 		return
 	}
-	err := r.nc.Publish(env.EnsurePrefixed("parties.invite.expire"), serialized)
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("parties.invite.expire"), serialized)
 	if err != nil {
-		log.Printf("Error publishing invite (%v) expiry: %v", requestUUID, err)
+		logger.ErrorContext(ctx, "failed to publish party invite expiry", "invite", requestUUID, "err", err)
+		return
 	}
+	logger.InfoContext(ctx, "party invite expired", "invite", requestUUID, "party", invite.PartyID)
 }
 
 func (r *InviteRegistry) contains(invite PartyInvite) bool {
@@ -0,0 +1,184 @@
+package parties
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// JoinRequestRegistry stores requests to join closed parties. It is InviteRegistry in reverse: the player
+// asks, and the party's leader or moderators answer.
+type JoinRequestRegistry struct {
+	mu sync.Mutex
+	// keyed by REQUEST uuid
+	requests      map[UUID]PartyJoinRequest
+	expiries      expiries
+	partyRegistry *PartyRegistry
+	nc            *nats.Conn
+}
+
+func NewJoinRequestRegistry(conn *nats.Conn, registry *PartyRegistry) *JoinRequestRegistry {
+	r := &JoinRequestRegistry{
+		requests:      make(map[UUID]PartyJoinRequest),
+		expiries:      make(expiries),
+		partyRegistry: registry,
+		nc:            conn,
+	}
+	registry.OnBan(r.dropBanned)
+	return r
+}
+
+func (r *JoinRequestRegistry) Send(ctx context.Context, partyID UUID, player UUID) (sent *PartyJoinRequest, error string) {
+	ctx, span := tracing.Start(ctx, "JoinRequestRegistry.Send",
+		attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.join_request", player.String(), partyID, UUID(uuid.Nil), audit.Result(sent != nil, error), nil)
+	}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	party := r.partyRegistry.GetParty(partyID)
+	if party == nil {
+		return nil, "ERR_INVALID_PARTY"
+	}
+	if r.partyRegistry.IsInParty(player) {
+		return nil, "ERR_ALREADY_IN_PARTY"
+	}
+	if code := party.JoinBlocked(player, time.Now()); code != "" {
+		return nil, code
+	}
+	if party.Open {
+		return nil, "ERR_PARTY_OPEN" // they can join right away
+	}
+	for _, request := range r.requests {
+		if request.PartyID == partyID && request.PlayerID == player {
+			return nil, "ERR_ALREADY_REQUESTED"
+		}
+	}
+
+	expiry := config.Get().Parties.JoinRequestExpiry.D()
+	request := PartyJoinRequest{
+		ID:       UUID(uuid.New()),
+		PartyID:  partyID,
+		PlayerID: player,
+		Expiry:   time.Now().Add(expiry),
+	}
+	// the request's span has ended by the time the request expires
+	detached := context.WithoutCancel(ctx)
+	r.expiries.schedule(request.ID, expiry, func() {
+		r.expireRequest(detached, request.ID)
+	})
+	r.requests[request.ID] = request
+
+	logger.InfoContext(ctx, "party join request sent", "party", partyID, "request", request.ID, "player", player)
+	return &request, ""
+}
+
+// Respond approves or denies the request. Approved players join the party, if it still has room.
+func (r *JoinRequestRegistry) Respond(ctx context.Context, id UUID, responder UUID, approve bool) (answered *PartyJoinRequest, error string) {
+	ctx, span := tracing.Start(ctx, "JoinRequestRegistry.Respond",
+		attribute.Stringer("cydian.request_id", id), attribute.Stringer("cydian.sender_id", responder))
+	defer span.End()
+	var request PartyJoinRequest
+	defer func() {
+		action := "party.join_request.deny"
+		if approve {
+			action = "party.join_request.approve"
+		}
+		record(ctx, action, responder.String(), request.PartyID, request.PlayerID, audit.Result(answered != nil, error), nil)
+	}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request, ok := r.requests[id]
+	if !ok {
+		return nil, "ERR_INVALID_REQUEST"
+	}
+	party := r.partyRegistry.GetParty(request.PartyID)
+	if party == nil {
+		r.takeInternal(id)
+		return nil, "ERR_INVALID_PARTY"
+	}
+	if party.CurrentLeader != responder && !party.IsModerator(responder) {
+		return nil, "ERR_NO_PERMISSION"
+	}
+
+	r.takeInternal(id)
+	if approve {
+		// an approved request lets them into a closed party, without taking an invite they may have
+		success, err := r.partyRegistry.JoinParty(ctx, request.PartyID, request.PlayerID, JoinRequest)
+		if !success {
+			return nil, audit.Result(false, err)
+		}
+	}
+
+	logger.InfoContext(ctx, "party join request answered", "request", id, "party", request.PartyID,
+		"player", request.PlayerID, "responder", responder, "approved", approve)
+	return &request, ""
+}
+
+// dropBanned removes the requests a player banned from the party had open to it
+func (r *JoinRequestRegistry) dropBanned(ctx context.Context, partyID UUID, player UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, request := range r.requests {
+		if request.PartyID == partyID && request.PlayerID == player {
+			r.takeInternal(id)
+			record(ctx, "party.join_request.deny", audit.ActorSystem, partyID, player, audit.ResultSuccess, map[string]any{"reason": "banned"})
+			logger.InfoContext(ctx, "party join request dropped, player banned", "request", id, "party", partyID, "player", player)
+		}
+	}
+}
+
+// GetAll returns all open join requests
+func (r *JoinRequestRegistry) GetAll() []PartyJoinRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requests := make([]PartyJoinRequest, 0, len(r.requests))
+	for _, request := range r.requests {
+		requests = append(requests, request)
+	}
+	return requests
+}
+
+func (r *JoinRequestRegistry) expireRequest(ctx context.Context, id UUID) {
+	ctx, span := tracing.Start(ctx, "JoinRequestRegistry.expireRequest", attribute.Stringer("cydian.request_id", id))
+	defer span.End()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request, ok := r.requests[id]
+	if !ok {
+		return
+	}
+	r.takeInternal(id)
+	record(ctx, "party.join_request.expire", audit.ActorSystem, request.PartyID, request.PlayerID, audit.ResultSuccess, nil)
+
+	msg, err := json.Marshal(&PartyJoinRequestPacket{Request: request})
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal party join request expiry", "request", id, "err", err)
+		return
+	}
+	if err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.join_request.expire.notify"), msg); err != nil {
+		logger.ErrorContext(ctx, "failed to publish party join request expiry", "request", id, "err", err)
+		return
+	}
+	logger.InfoContext(ctx, "party join request expired", "request", id, "party", request.PartyID)
+}
+
+// takeInternal removes the request and stops its expiry timer
+func (r *JoinRequestRegistry) takeInternal(id UUID) {
+	delete(r.requests, id)
+	r.expiries.cancel(id)
+}
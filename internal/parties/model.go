@@ -2,6 +2,7 @@ package parties
 
 import (
 	"encoding/json"
+	"maps"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,6 +27,10 @@ func (u UUID) MarshalJSON() ([]byte, error) {
 	return json.Marshal(uuid.UUID(u).String())
 }
 
+func (u UUID) String() string {
+	return uuid.UUID(u).String()
+}
+
 func (u UUID) MarshalText() ([]byte, error) {
 	return []byte(uuid.UUID(u).String()), nil
 }
@@ -48,6 +53,28 @@ type Party struct {
 	OpenInvites   bool                 `json:"open_invites"`
 	Muted         bool                 `json:"muted"`          // no one can speak except for moderators
 	ActiveInvites map[UUID]PartyInvite `json:"active_invites"` // keyed by invite uuid
+	Version       uint64               `json:"version"`        // the sequence of the last event that changed the party
+	Listing       PartyListing         `json:"listing"`        // how the party shows up in the party finder
+	Bans          *Set                 `json:"bans"`           // players who can't join or be invited
+	MutedMembers  *Set                 `json:"muted_members"`  // can't use party chat, kept if they leave and rejoin
+	// KickCooldowns keeps kicked players from rejoining until the time passes
+	KickCooldowns map[UUID]time.Time `json:"kick_cooldowns,omitempty"`
+	// Offline holds the disconnected players, with when they're removed unless they reconnect first.
+	// Everyone else in the party is online.
+	Offline map[UUID]time.Time `json:"offline,omitempty"`
+	// DisplacedLeader lost leadership by disconnecting, and may get it back by reconnecting
+	DisplacedLeader *UUID `json:"displaced_leader,omitempty"`
+	// JoinedAt is when each player, the leader included, joined the party. Leaders are succeeded by tenure.
+	JoinedAt   map[UUID]time.Time `json:"joined_at"`
+	Succession string             `json:"succession,omitempty"` // picks the next leader, SuccessionModerators when empty
+}
+
+// PartyListing describes a party in the party finder. Only open, listed parties with room are shown.
+type PartyListing struct {
+	Listed   bool   `json:"listed"`
+	Title    string `json:"title,omitempty"`
+	Tag      string `json:"tag,omitempty"`      // the game mode, ie: bedwars
+	Language string `json:"language,omitempty"` // ie: en
 }
 
 type Set struct {
@@ -99,6 +126,14 @@ func (s *Set) Slice() []UUID {
 	return keys
 }
 
+// Clone returns a copy of the set, nil for a nil set
+func (s *Set) Clone() *Set {
+	if s == nil {
+		return nil
+	}
+	return &Set{elements: maps.Clone(s.elements)}
+}
+
 func (s *Set) UnmarshalJSON(data []byte) error {
 	var slice []UUID
 	if err := json.Unmarshal(data, &slice); err != nil {
@@ -113,6 +148,75 @@ func (s Set) MarshalJSON() ([]byte, error) {
 	return json.Marshal(s.Slice())
 }
 
+// Clone returns a deep copy, so callers never share sets or invites with the registry
+func (p Party) Clone() Party {
+	p.Moderators = p.Moderators.Clone()
+	p.Members = p.Members.Clone()
+	p.ActiveInvites = maps.Clone(p.ActiveInvites)
+	p.Bans = p.Bans.Clone()
+	p.MutedMembers = p.MutedMembers.Clone()
+	p.KickCooldowns = maps.Clone(p.KickCooldowns)
+	p.Offline = maps.Clone(p.Offline)
+	p.JoinedAt = maps.Clone(p.JoinedAt)
+	return p
+}
+
+// IsOnline reports whether the player hasn't disconnected. Players outside the party count as online.
+func (p Party) IsOnline(player UUID) bool {
+	_, offline := p.Offline[player]
+	return !offline
+}
+
+// setOffline marks the player as disconnected until they reconnect or the deadline removes them
+func (p *Party) setOffline(player UUID, deadline time.Time) {
+	if p.Offline == nil {
+		p.Offline = make(map[UUID]time.Time)
+	}
+	p.Offline[player] = deadline
+}
+
+// forget drops the party's offline state for a player who reconnected or left
+func (p *Party) forget(player UUID) {
+	delete(p.Offline, player)
+	if p.DisplacedLeader != nil && *p.DisplacedLeader == player {
+		p.DisplacedLeader = nil
+	}
+}
+
+// setJoined records when the player joined, for succession
+func (p *Party) setJoined(player UUID, at time.Time) {
+	if p.JoinedAt == nil {
+		p.JoinedAt = make(map[UUID]time.Time)
+	}
+	p.JoinedAt[player] = at
+}
+
+// drop forgets everything the party tracks about a player who left it, other than bans, cooldowns and mutes
+func (p *Party) drop(player UUID) {
+	p.forget(player)
+	delete(p.JoinedAt, player)
+}
+
+// JoinBlocked returns why the player may not join or be invited, or an empty string if they may
+func (p Party) JoinBlocked(player UUID, now time.Time) string {
+	if p.Bans.Contains(player) {
+		return "ERR_BANNED"
+	}
+	if until, ok := p.KickCooldowns[player]; ok && now.Before(until) {
+		return "ERR_KICK_COOLDOWN"
+	}
+	return ""
+}
+
+// pruneCooldowns forgets the kick cooldowns that ran out before now
+func (p *Party) pruneCooldowns(now time.Time) {
+	for player, until := range p.KickCooldowns {
+		if !now.Before(until) {
+			delete(p.KickCooldowns, player)
+		}
+	}
+}
+
 func (p Party) IsInParty(playerID UUID) bool {
 	// Check leader first
 	if p.CurrentLeader == playerID {
@@ -144,24 +248,92 @@ func (p Party) TotalSize() int {
 	return p.Members.Size() + p.Moderators.Size() + 1
 }
 
+// Occupancy counts the players and outstanding invites, which both take up room under the size cap
+func (p Party) Occupancy() int {
+	return p.TotalSize() + len(p.ActiveInvites)
+}
+
 type PartyInvite struct {
 	ID        UUID      `json:"id"`
 	PartyID   UUID      `json:"party_id"`
 	Recipient UUID      `json:"recipient"`
 	SenderID  UUID      `json:"sender_id"` // can be a moderator or anyone if OpenInvites is enabled
+	SentAt    time.Time `json:"sent_at"`
 	Expiry    time.Time `json:"expiry"`
 }
 
+// PartyJoinRequest is a player asking to join a closed party, answered by its leader or moderators
+type PartyJoinRequest struct {
+	ID       UUID      `json:"id"`
+	PartyID  UUID      `json:"party_id"`
+	PlayerID UUID      `json:"player_id"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+type PartyJoinRequestSendPacket struct {
+	PartyID  UUID `json:"party_id"`
+	PlayerID UUID `json:"player_id"`
+}
+
+type PartyJoinRequestRespondPacket struct {
+	RequestID   UUID `json:"request_id"`
+	ResponderID UUID `json:"responder_id"` // the leader or a moderator
+	Approve     bool `json:"approve"`
+}
+
+type PartyJoinRequestPacket struct {
+	Request PartyJoinRequest `json:"request"`
+}
+
+// PartyJoinRequestResponsePacket is broadcast once a join request was approved or denied
+type PartyJoinRequestResponsePacket struct {
+	Request     PartyJoinRequest `json:"request"`
+	ResponderID UUID             `json:"responder_id"`
+	Approved    bool             `json:"approved"`
+}
+
 type PartyInviteSendPacket struct {
-	PartyID     *UUID `json:"party_id"` // this may be nil
-	SenderID    UUID  `json:"sender_id"`
-	RecipientID UUID  `json:"recipient_id"`
+	PartyID     *UUID  `json:"party_id"` // this may be nil
+	SenderID    UUID   `json:"sender_id"`
+	RecipientID UUID   `json:"recipient_id"`
+	SenderRank  string `json:"sender_rank,omitempty"` // sizes the party while the sender leads it, instead of the rank lookup
 }
 
 type PartyInviteAcceptPacket struct {
 	RequestID UUID `json:"request_id"`
 }
 
+// PartyInviteAcceptBySenderPacket accepts the recipient's invite from the sender, or from the party they lead
+type PartyInviteAcceptBySenderPacket struct {
+	RecipientID UUID `json:"recipient_id"`
+	SenderID    UUID `json:"sender_id"`
+}
+
+// PartyInviteAcceptLatestPacket accepts the most recent invite the recipient received
+type PartyInviteAcceptLatestPacket struct {
+	RecipientID UUID `json:"recipient_id"`
+}
+
+// PartyInviteDeclinePacket is sent by the recipient of an invite to decline it
+type PartyInviteDeclinePacket struct {
+	RequestID UUID `json:"request_id"`
+	PlayerID  UUID `json:"player_id"`
+}
+
+// PartyInviteRevokePacket is sent by whoever sent an invite, or their party leader, to take it back
+type PartyInviteRevokePacket struct {
+	RequestID UUID `json:"request_id"`
+	SenderID  UUID `json:"sender_id"`
+}
+
+type PartyInviteListRequest struct {
+	PlayerID UUID `json:"player_id"`
+}
+
+type PartyInviteListResponse struct {
+	Invites []PartyInvite `json:"invites"`
+}
+
 type PartyInviteExpirePacket struct {
 	RequestID UUID `json:"request_id"`
 	PartyID   UUID `json:"party_id"`
@@ -206,3 +378,80 @@ type PartyStateChangePacket struct {
 type PartyCreatePacket struct {
 	Party Party `json:"party"`
 }
+
+// PartyFetchRequest narrows party.fetch.request down to the party of a player, or a party by ID.
+// An empty request still returns every party.
+type PartyFetchRequest struct {
+	PlayerID *UUID `json:"player_id,omitempty"`
+	PartyID  *UUID `json:"party_id,omitempty"`
+}
+
+type PartyMemberMutePacket struct {
+	PartyID  UUID `json:"party_id"`
+	SenderID UUID `json:"sender_id"`
+	PlayerID UUID `json:"player_id"`
+	State    bool `json:"state"` // false lifts the mute
+}
+
+type PartyChatSendPacket struct {
+	PlayerID UUID   `json:"player_id"`
+	Message  string `json:"message"`
+}
+
+// PartyChatNotifyPacket is sent to each server hosting party members, Recipients are the ones on that server
+type PartyChatNotifyPacket struct {
+	PartyID    UUID      `json:"party_id"`
+	SenderID   UUID      `json:"sender_id"`
+	Message    string    `json:"message"`
+	Recipients []UUID    `json:"recipients"`
+	Time       time.Time `json:"time"`
+}
+
+// PartySuccessionPacket changes how the party's next leader is picked. Only the leader may send it.
+type PartySuccessionPacket struct {
+	PartyID  UUID   `json:"party_id"`
+	PlayerID UUID   `json:"player_id"`
+	Policy   string `json:"policy"`
+}
+
+type PartyListingPacket struct {
+	PartyID  UUID         `json:"party_id"`
+	PlayerID UUID         `json:"player_id"`
+	Listing  PartyListing `json:"listing"`
+}
+
+// PartyFinderRequest filters the party finder. Empty fields match every party.
+type PartyFinderRequest struct {
+	Tag      string `json:"tag,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+type PartyFinderEntry struct {
+	PartyID  UUID   `json:"party_id"`
+	Title    string `json:"title"`
+	Tag      string `json:"tag"`
+	Language string `json:"language"`
+	Leader   UUID   `json:"leader"`
+	Size     int    `json:"size"`
+	MaxSize  int    `json:"max_size"`
+}
+
+type PartyFinderResponse struct {
+	Parties []PartyFinderEntry `json:"parties"`
+}
+
+type PartySyncRequest struct {
+	Since uint64 `json:"since"` // the version the server is up to date with, 0 for everything
+	Epoch UUID   `json:"epoch"` // from the response Since came from
+}
+
+// PartySyncResponse holds the parties changed after the requested version and the IDs of the ones
+// disbanded since. When Full is set, Parties is every party and the server should drop what it has.
+// Versions are only comparable within an Epoch, which changes whenever Cydian restarts.
+type PartySyncResponse struct {
+	Version uint64  `json:"version"` // to send as Since next time
+	Epoch   UUID    `json:"epoch"`   // to send back with Since
+	Full    bool    `json:"full"`
+	Parties []Party `json:"parties"`
+	Removed []UUID  `json:"removed"`
+}
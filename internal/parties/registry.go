@@ -3,34 +3,214 @@ package parties
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/config"
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var logger = logging.For("parties")
+
+// PartyRegistry holds every party. Each party has its own lock, so operations on different parties,
+// broadcasts included, don't wait for each other. Locks are taken in this order: the invite registry,
+// then a party, then the registry itself. r.mu is never held while waiting for a party.
 type PartyRegistry struct {
-	mu          sync.Mutex
-	parties     map[UUID]Party
-	disconnects map[UUID]context.CancelFunc
+	mu          sync.Mutex // guards everything below except the contents of an entry
+	parties     map[UUID]*partyEntry
+	players     map[UUID]UUID // every player in a party, to the party's ID
+	disconnects expiries      // removal timers of offline players, by player
 	nc          *nats.Conn
+	events      EventLog
+	sequence    uint64 // of the last emitted event
+	// tombstones remember disbanded parties for Sync, by the sequence of their disband event
+	tombstones map[UUID]tombstone
+	// syncFloor is the oldest version Sync can return changes since, older ones may have missed
+	// a restart or a pruned tombstone
+	syncFloor uint64
+	// epoch identifies this run of the registry. Sequences restart with it unless there's an event log,
+	// so versions from another epoch can't be trusted.
+	epoch      UUID
+	rankLookup RankLookup
+	// banListeners drop what other registries hold for a banned player, see OnBan
+	banListeners []BanListener
+}
+
+// BanListener is told about a player banned from a party. It's called without any party locked.
+type BanListener func(ctx context.Context, partyID UUID, player UUID)
+
+// RankLookup returns a player's rank, which picks their party's size cap while they lead it.
+// ok is false when the rank isn't known.
+type RankLookup func(ctx context.Context, player UUID) (rank string, ok bool)
+
+// partyEntry is a party and the lock serialising changes to it
+type partyEntry struct {
+	mu      sync.Mutex
+	party   Party
+	removed bool // set when disbanded, for callers that were waiting on the lock
+	// rank was provided by a caller for rankOf, and is used while they lead the party
+	rank   string
+	rankOf UUID
+}
+
+type tombstone struct {
+	sequence uint64
+	at       time.Time
 }
 
 func NewPartyRegistry(nc *nats.Conn) *PartyRegistry {
 	return &PartyRegistry{
 		mu:          sync.Mutex{},
-		parties:     make(map[UUID]Party),
-		disconnects: make(map[UUID]context.CancelFunc),
+		parties:     make(map[UUID]*partyEntry),
+		players:     make(map[UUID]UUID),
+		disconnects: make(expiries),
 		nc:          nc,
+		events:      noEventLog{},
+		tombstones:  make(map[UUID]tombstone),
+		epoch:       UUID(uuid.New()),
+	}
+}
+
+// OnBan adds a listener called after every successful ban
+func (r *PartyRegistry) OnBan(listener BanListener) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.banListeners = append(r.banListeners, listener)
+}
+
+// SetRankLookup sets where leader ranks come from when no caller provided them
+func (r *PartyRegistry) SetRankLookup(lookup RankLookup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rankLookup = lookup
+}
+
+// capacity returns how many players and outstanding invites the party may hold. The entry's lock must be held.
+func (r *PartyRegistry) capacity(ctx context.Context, entry *partyEntry) int {
+	leader := entry.party.CurrentLeader
+	rank := ""
+	if entry.rankOf == leader {
+		rank = entry.rank
+	}
+	if rank == "" {
+		r.mu.Lock()
+		lookup := r.rankLookup
+		r.mu.Unlock()
+		if lookup != nil {
+			rank, _ = lookup(ctx, leader)
+		}
+	}
+	cfg := config.Get().Parties
+	if size, ok := cfg.RankMaxSizes[rank]; ok && rank != "" {
+		return size
+	}
+	return cfg.MaxSize
+}
+
+// lockParty returns the party's entry locked, or nil when there is no such party
+func (r *PartyRegistry) lockParty(id UUID) *partyEntry {
+	r.mu.Lock()
+	entry, ok := r.parties[id]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	entry.mu.Lock()
+	if entry.removed {
+		entry.mu.Unlock()
+		return nil
+	}
+	return entry
+}
+
+// lockPlayerParty returns the entry of the player's party locked, or nil when they aren't in one
+func (r *PartyRegistry) lockPlayerParty(player UUID) *partyEntry {
+	for {
+		r.mu.Lock()
+		id, ok := r.players[player]
+		r.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		entry := r.lockParty(id)
+		if entry != nil && entry.party.IsInParty(player) {
+			return entry
+		}
+		if entry != nil {
+			entry.mu.Unlock()
+		}
+
+		// the player left or joined another party while we waited, look again unless the index is unchanged
+		r.mu.Lock()
+		current, ok := r.players[player]
+		r.mu.Unlock()
+		if !ok || current == id {
+			return nil
+		}
+	}
+}
+
+// SetEventLog starts emitting events to log, numbering them after lastSequence. A reset event is emitted
+// first, since the parties of earlier events didn't survive the restart.
+func (r *PartyRegistry) SetEventLog(ctx context.Context, log EventLog, lastSequence uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = log
+	r.sequence = lastSequence
+	r.emitInternal(ctx, nil, PartyEvent{Type: EventReset})
+	r.syncFloor = r.sequence
+}
+
+// emit numbers the event, stamps the party with it as its version and appends it to the event log.
+// The entry's lock must be held, and the change must already be applied to it.
+func (r *PartyRegistry) emit(ctx context.Context, entry *partyEntry, event PartyEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emitInternal(ctx, entry, event)
+}
+
+// emitInternal is emit for callers already holding r.mu. entry may be nil for events without a party.
+// The event's Time is now, unless the caller set it.
+func (r *PartyRegistry) emitInternal(ctx context.Context, entry *partyEntry, event PartyEvent) {
+	r.sequence++
+	event.Sequence = r.sequence
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if entry != nil {
+		entry.party.Version = r.sequence
+	}
+	if event.Party != nil {
+		event.Party.Version = r.sequence
+	}
+	switch event.Type {
+	case EventCreated:
+		delete(r.tombstones, event.PartyID)
+	case EventDisbanded:
+		r.tombstones[event.PartyID] = tombstone{sequence: r.sequence, at: event.Time}
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal party event", "sequence", event.Sequence, "type", event.Type, "err", err)
+		return
 	}
+	r.events.Append(event, data)
 }
 
-func (r *PartyRegistry) CreateParty(id UUID, owner UUID, initialInvite *PartyInvite) Party {
-	party := Party{
+// CreateParty creates a party led by owner. It fails with ERR_ALREADY_IN_PARTY if the owner joined or
+// created another party since the caller last checked.
+func (r *PartyRegistry) CreateParty(ctx context.Context, id UUID, owner UUID, initialInvite *PartyInvite) (created *Party, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.CreateParty", attribute.Stringer("cydian.party_id", id))
+	defer span.End()
+	entry := &partyEntry{party: Party{
 		ID:            id,
 		CurrentLeader: owner,
 		Moderators:    NewSet(),
@@ -39,94 +219,121 @@ func (r *PartyRegistry) CreateParty(id UUID, owner UUID, initialInvite *PartyInv
 		OpenInvites:   false,
 		Muted:         false,
 		ActiveInvites: make(map[UUID]PartyInvite),
-	}
+		Bans:          NewSet(),
+		MutedMembers:  NewSet(),
+		JoinedAt:      map[UUID]time.Time{owner: time.Now().UTC()},
+		Succession:    SuccessionModerators,
+	}}
 	if initialInvite != nil {
-		party.ActiveInvites[initialInvite.ID] = *initialInvite
+		entry.party.ActiveInvites[initialInvite.ID] = *initialInvite
 	}
+	entry.mu.Lock()
 	r.mu.Lock()
-	r.parties[party.ID] = party
+	if _, ok := r.players[owner]; ok {
+		r.mu.Unlock()
+		entry.mu.Unlock()
+		record(ctx, "party.create", owner.String(), id, UUID(uuid.Nil), "ERR_ALREADY_IN_PARTY", nil)
+		return nil, "ERR_ALREADY_IN_PARTY"
+	}
+	r.parties[id] = entry
+	r.players[owner] = id
+	r.emitInternal(ctx, entry, PartyEvent{Type: EventCreated, PartyID: id, Actor: ref(owner), Party: &entry.party})
 	r.mu.Unlock()
+	party := entry.party.Clone()
+	entry.mu.Unlock()
+	record(ctx, "party.create", owner.String(), party.ID, UUID(uuid.Nil), audit.ResultSuccess, nil)
 
 	msg, err := json.Marshal(PartyCreatePacket{Party: party})
 	if err != nil {
-		log.Printf("Failed to marshal party create packet: %v", err)
+		logger.ErrorContext(ctx, "failed to marshal party create packet", "err", err)
 	}
-	_ = r.nc.Publish(env.EnsurePrefixed("party.create.notify"), msg)
-	log.Printf("create notification")
-	return party
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.create.notify"), msg)
+	logger.InfoContext(ctx, "party created", "party", party.ID, "leader", owner)
+	return &party, ""
 }
 
-func (r *PartyRegistry) TrackInvite(partyID UUID, invite PartyInvite) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if !r.containsKeyInternal(partyID) {
-		return
+// TrackInvite adds the invite to the party, unless the party is full. Invites to parties that don't exist
+// yet are ignored, they are passed to CreateParty instead. senderRank may be empty.
+func (r *PartyRegistry) TrackInvite(ctx context.Context, partyID UUID, invite PartyInvite, senderRank string) string {
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		return ""
 	}
+	defer entry.mu.Unlock()
 
-	party := r.parties[partyID]
-	invites := party.ActiveInvites
-	invites[invite.ID] = invite
-	party.ActiveInvites = invites
-	r.parties[partyID] = party
+	if senderRank != "" && invite.SenderID == entry.party.CurrentLeader {
+		entry.rank, entry.rankOf = senderRank, invite.SenderID
+	}
+	if entry.party.Occupancy() >= r.capacity(ctx, entry) {
+		return "ERR_PARTY_FULL"
+	}
+	entry.party.ActiveInvites[invite.ID] = invite
+	r.emit(ctx, entry, PartyEvent{Type: EventInviteAdded, PartyID: partyID, Actor: ref(invite.SenderID), Player: ref(invite.Recipient), Invite: &invite})
+	return ""
 }
 
-func (r *PartyRegistry) RemoveInvite(partyID UUID, inviteID UUID) bool {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if !r.containsKeyInternal(partyID) {
-		log.Printf("Failed to remove invite: registry does not contain party: %s", partyID)
+// RemoveInvite drops the invite from the party for the reason, disbanding the party if no one else is left.
+// It returns false when the invite wasn't found or the party was disbanded.
+func (r *PartyRegistry) RemoveInvite(ctx context.Context, partyID UUID, inviteID UUID, reason string) bool {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.RemoveInvite", attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		logger.WarnContext(ctx, "failed to remove invite, unknown party", "party", partyID, "invite", inviteID)
 		return false
 	}
+	defer entry.mu.Unlock()
 
-	party := r.parties[partyID]
-	invites := party.ActiveInvites
-	_, ok := invites[inviteID]
+	party := &entry.party
+	invite, ok := party.ActiveInvites[inviteID]
 	if !ok {
 		return false
 	}
-	delete(invites, inviteID)
-	party.ActiveInvites = invites
-	r.parties[partyID] = party
+	delete(party.ActiveInvites, inviteID)
+	r.emit(ctx, entry, PartyEvent{Type: EventInviteRemoved, PartyID: partyID, Player: ref(invite.Recipient), Reason: reason, Invite: &invite})
 
 	// check for an empty party and remove it
 	if party.TotalSize() <= 1 {
-		r.disbandForEmptyInternal(partyID)
+		r.disbandForEmpty(ctx, entry)
 		return false
 	}
 	return true
 }
 
-func (r *PartyRegistry) disbandForEmptyInternal(partyID UUID) {
-	delete(r.parties, partyID)
+// disbandForEmpty removes a party that has no one left to play with. The entry's lock must be held.
+func (r *PartyRegistry) disbandForEmpty(ctx context.Context, entry *partyEntry) {
+	partyID := entry.party.ID
+	r.mu.Lock()
+	r.removePartyInternal(entry)
+	r.emitInternal(ctx, nil, PartyEvent{Type: EventDisbanded, PartyID: partyID, Reason: "empty"})
+	r.mu.Unlock()
+	record(ctx, "party.disband.empty", audit.ActorSystem, partyID, UUID(uuid.Nil), audit.ResultSuccess, nil)
 
-	log.Printf("Party %s has been disbanded due to being empty", partyID)
+	logger.InfoContext(ctx, "party disbanded for being empty", "party", partyID)
 
 	msg, _ := json.Marshal(&PartyOnePlayerPacket{
 		PartyID:  partyID,
 		PlayerID: UUID(uuid.Nil),
 	})
-	err := r.nc.Publish(env.EnsurePrefixed("party.disband.notify.empty"), msg)
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.disband.notify.empty"), msg)
 	if err != nil {
-		log.Printf("Failed to broadcast party disband: %v", err)
+		logger.ErrorContext(ctx, "failed to broadcast party disband", "party", partyID, "err", err)
 	}
 }
 
-func (r *PartyRegistry) disbandForEmpty(partyID UUID) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.disbandForEmptyInternal(partyID)
-}
-
-func (r *PartyRegistry) Disband(partyID UUID, sender UUID) (bool, string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+func (r *PartyRegistry) Disband(ctx context.Context, partyID UUID, sender UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Disband",
+		attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.sender_id", sender))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.disband", sender.String(), partyID, UUID(uuid.Nil), audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
-	if party.CurrentLeader != sender {
+	defer entry.mu.Unlock()
+	if entry.party.CurrentLeader != sender {
 		return false, "ERR_NOT_LEADER"
 	}
 
@@ -134,83 +341,172 @@ func (r *PartyRegistry) Disband(partyID UUID, sender UUID) (bool, string) {
 		PartyID:  partyID,
 		PlayerID: sender,
 	})
-	err := r.nc.Publish(env.EnsurePrefixed("party.disband.notify.command"), msg)
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.disband.notify.command"), msg)
 	if err != nil {
 		return false, "ERR_BROADCAST_FAILED"
 	}
 
-	delete(r.parties, partyID)
+	r.mu.Lock()
+	r.removePartyInternal(entry)
+	r.emitInternal(ctx, nil, PartyEvent{Type: EventDisbanded, PartyID: partyID, Actor: ref(sender), Reason: "command"})
+	r.mu.Unlock()
 
-	log.Printf("Party %s has been disbanded by %s", partyID, sender)
+	logger.InfoContext(ctx, "party disbanded", "party", partyID, "sender", sender)
 	return true, ""
 }
 
-func (r *PartyRegistry) JoinParty(partyID UUID, player UUID, fromInvite bool) (success bool, error string) {
+// ForceDisband disbands a party regardless of who is asking. It's meant for staff tooling.
+func (r *PartyRegistry) ForceDisband(ctx context.Context, partyID UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.ForceDisband", attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.disband.forced", audit.Actor(ctx, audit.ActorStaff), partyID, UUID(uuid.Nil), audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		return false, "ERR_INVALID_PARTY"
+	}
+	defer entry.mu.Unlock()
+
+	msg, _ := json.Marshal(&PartyOnePlayerPacket{
+		PartyID:  partyID,
+		PlayerID: UUID(uuid.Nil),
+	})
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.disband.notify.forced"), msg)
+	if err != nil {
+		return false, "ERR_BROADCAST_FAILED"
+	}
+
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.removePartyInternal(entry)
+	r.emitInternal(ctx, nil, PartyEvent{Type: EventDisbanded, PartyID: partyID, Reason: "forced"})
+	r.mu.Unlock()
+
+	logger.InfoContext(ctx, "party force disbanded", "party", partyID)
+	return true, ""
+}
+
+// JoinSource is how a player got into a party
+type JoinSource string
+
+const (
+	JoinOpen    JoinSource = "open"    // the party is open to anyone
+	JoinBypass  JoinSource = "bypass"  // staff let them in, consuming any invite they had
+	JoinInvite  JoinSource = "invite"  // they accepted an invite
+	JoinRequest JoinSource = "request" // the party approved their join request
+)
+
+// JoinParty adds player to the party. Only JoinOpen needs the party to be open, and only invites and
+// bypasses consume the player's pending invite.
+func (r *PartyRegistry) JoinParty(ctx context.Context, partyID UUID, player UUID, source JoinSource) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.JoinParty",
+		attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.join", player.String(), partyID, UUID(uuid.Nil), audit.Result(success, error),
+			map[string]any{"source": source})
+	}()
 	//todo: consider performing online checks on this player
 	// It would need to involve a redis check
-	if !r.containsKeyInternal(partyID) {
-		log.Printf("Failed to join party: registry does not contain party: %s", partyID)
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		logger.WarnContext(ctx, "failed to join party, unknown party", "party", partyID, "player", player)
 		return
 	}
+	defer entry.mu.Unlock()
 
-	if r.isInPartyInternal(player) {
-		return false, "ERR_ALREADY_IN_PARTY"
+	party := &entry.party
+	if code := party.JoinBlocked(player, time.Now()); code != "" {
+		return false, code
 	}
-
-	party := r.parties[partyID]
-	if !fromInvite && !party.Open {
+	if source == JoinOpen && !party.Open {
 		return false, "ERR_NO_INVITE"
 	}
 
-	if fromInvite {
-		// remove invite
-		var toRemove *UUID = nil
+	var accepted *PartyInvite
+	if source == JoinInvite || source == JoinBypass {
 		for _, invite := range party.ActiveInvites {
 			if invite.Recipient == player {
-				toRemove = &invite.ID
+				accepted = &invite
 			}
 		}
-		if toRemove != nil { // this is used for bypass, so may be nil
-			invites := party.ActiveInvites
-			delete(invites, *toRemove)
-			party.ActiveInvites = invites
-		}
+	}
+	// an accepted invite already holds the player's place
+	if accepted == nil && party.Occupancy() >= r.capacity(ctx, entry) {
+		return false, "ERR_PARTY_FULL"
+	}
+
+	// claim the player in the index first, so they can't join two parties at once
+	r.mu.Lock()
+	if _, ok := r.players[player]; ok {
+		r.mu.Unlock()
+		return false, "ERR_ALREADY_IN_PARTY"
+	}
+	r.players[player] = partyID
+	r.mu.Unlock()
+
+	if accepted != nil { // a bypass may not have one
+		// remove invite
+		delete(party.ActiveInvites, accepted.ID)
+		r.emit(ctx, entry, PartyEvent{Type: EventInviteRemoved, PartyID: partyID, Player: ref(player), Reason: "accepted", Invite: accepted})
 	}
 
 	msg, _ := json.Marshal(&PartyOnePlayerPacket{
 		PartyID:  partyID,
 		PlayerID: player,
 	})
-	err := r.nc.Publish(env.EnsurePrefixed("party.join.notify"), msg)
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.join.notify"), msg)
 	if err != nil {
+		r.mu.Lock()
+		delete(r.players, player)
+		r.mu.Unlock()
 		return false, "ERR_BROADCAST_FAILED"
 	}
 
+	// the event carries the join time, so replays agree on tenure
+	joined := time.Now().UTC()
 	party.Members.Add(player)
-	r.parties[partyID] = party
-	log.Printf("PlayerID %s joined party %s", player, partyID)
+	party.setJoined(player, joined)
+	r.emit(ctx, entry, PartyEvent{Type: EventMemberJoined, PartyID: partyID, Player: ref(player), Time: joined, Reason: string(source)})
+	logger.InfoContext(ctx, "player joined party", "party", partyID, "player", player, "source", source)
 
 	return true, ""
 }
 
-func (r *PartyRegistry) DisconnectFromParty(player UUID) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if !r.isInPartyInternal(player) {
+// removeDisconnected removes a player whose grace period ran out. generation is that of the removal timer,
+// so a timer replaced by a later disconnect leaves the player alone.
+func (r *PartyRegistry) removeDisconnected(ctx context.Context, player UUID, generation uint64) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.removeDisconnected", attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	entry := r.lockPlayerParty(player)
+	if entry == nil {
 		return
 	}
-	_, party := r.getPlayerPartyInternal(player)
-
-	delete(r.disconnects, player)
+	defer entry.mu.Unlock()
+	party := &entry.party
+	r.mu.Lock()
+	current := r.disconnects.current(player, generation)
+	r.mu.Unlock()
+	if !current {
+		return // they reconnected while the removal waited for the lock, and may have disconnected again since
+	}
+	record(ctx, "party.remove_disconnected", audit.ActorSystem, party.ID, player, audit.ResultSuccess, nil)
 
 	if party.TotalSize() <= 2 {
 		// if a party has 2 members, it will be empty once the player is removed.
-		r.disbandForEmptyInternal(party.ID)
+		r.disbandForEmpty(ctx, entry)
+		return
+	}
+	if party.CurrentLeader == player && r.selectNewLeader(*party) == UUID(uuid.Nil) {
+		// everyone left is offline too, so no one can lead the party
+		r.disbandForEmpty(ctx, entry)
 		return
 	}
 
+	r.mu.Lock()
+	delete(r.players, player)
+	r.dropInternal(party, player)
+	r.mu.Unlock()
 	if party.IsMember(player) {
 		party.Members.Remove(player)
 	} else if party.IsModerator(player) {
@@ -219,43 +515,52 @@ func (r *PartyRegistry) DisconnectFromParty(player UUID) {
 		party.CurrentLeader = r.selectNewLeader(*party)
 		party.Members.Remove(party.CurrentLeader)
 		party.Moderators.Remove(party.CurrentLeader)
-		r.parties[party.ID] = *party
+		r.emit(ctx, entry, PartyEvent{Type: EventLeaderChanged, PartyID: party.ID, Player: ref(party.CurrentLeader), Reason: "disconnected"})
 
 		msg1, _ := json.Marshal(&PartyTwoPlayerPacket{
 			PartyID:  party.ID,
 			SenderID: player,
 			PlayerID: party.CurrentLeader,
 		})
-		_ = r.nc.Publish(env.EnsurePrefixed("party.transfer.notify.disconnected"), msg1)
+		_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.transfer.notify.disconnected"), msg1)
 		return
 	}
 
+	// the player is gone even if the broadcast fails
+	r.emit(ctx, entry, PartyEvent{Type: EventMemberLeft, PartyID: party.ID, Player: ref(player), Reason: "disconnected"})
+
 	msg, _ := json.Marshal(&PartyOnePlayerPacket{
 		PartyID:  party.ID,
 		PlayerID: player,
 	})
-	err := r.nc.Publish(env.EnsurePrefixed("party.leave.notify.disconnected"), msg)
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.leave.notify.disconnected"), msg)
 	if err != nil {
-		log.Printf("Failed to broadcast party remove disconnected: %v", err)
+		logger.ErrorContext(ctx, "failed to broadcast disconnected party leave", "party", party.ID, "player", player, "err", err)
 		return
 	}
 
-	r.parties[party.ID] = *party
-	log.Printf("PlayerID %s disconnected from party %s", player, party.ID)
+	logger.InfoContext(ctx, "player removed from party after disconnecting", "party", party.ID, "player", player)
 }
 
-func (r *PartyRegistry) LeaveParty(player UUID) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *PartyRegistry) LeaveParty(ctx context.Context, player UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.LeaveParty", attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	var partyID UUID
+	defer func() {
+		record(ctx, "party.leave", player.String(), partyID, UUID(uuid.Nil), audit.Result(success, error), nil)
+	}()
 
-	if !r.isInPartyInternal(player) {
+	entry := r.lockPlayerParty(player)
+	if entry == nil {
 		return false, "ERR_NOT_IN_PARTY"
 	}
+	defer entry.mu.Unlock()
 
-	_, party := r.getPlayerPartyInternal(player)
+	party := &entry.party
+	partyID = party.ID
 
 	if party.TotalSize() <= 2 {
-		r.disbandForEmptyInternal(party.ID)
+		r.disbandForEmpty(ctx, entry)
 		return true, ""
 	}
 
@@ -265,22 +570,26 @@ func (r *PartyRegistry) LeaveParty(player UUID) (success bool, error string) {
 
 		newLeader := r.selectNewLeader(*party)
 		if newLeader == UUID(uuid.Nil) {
-			// no new leader, disband party
-			r.disbandForEmptyInternal(party.ID)
+			// no one online to lead it, disband party
+			r.disbandForEmpty(ctx, entry)
 			return true, ""
 		}
 
 		party.CurrentLeader = newLeader
 		party.Members.Remove(newLeader)
 		party.Moderators.Remove(newLeader)
-		r.parties[party.ID] = *party
+		r.mu.Lock()
+		delete(r.players, player)
+		r.dropInternal(party, player)
+		r.emitInternal(ctx, entry, PartyEvent{Type: EventLeaderChanged, PartyID: party.ID, Player: ref(newLeader), Reason: "left"})
+		r.mu.Unlock()
 
 		msg, _ := json.Marshal(&PartyTwoPlayerPacket{
 			PartyID:  party.ID,
 			SenderID: player,
 			PlayerID: newLeader,
 		})
-		err := r.nc.Publish(env.EnsurePrefixed("party.transfer.notify.left"), msg)
+		err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.transfer.notify.left"), msg)
 		if err != nil {
 			return false, "ERR_BROADCAST_FAILED"
 		}
@@ -291,25 +600,36 @@ func (r *PartyRegistry) LeaveParty(player UUID) (success bool, error string) {
 		PartyID:  party.ID,
 		PlayerID: player,
 	})
-	err := r.nc.Publish(env.EnsurePrefixed("party.leave.notify.command"), msg)
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.leave.notify.command"), msg)
 	if err != nil {
 		return false, "ERR_BROADCAST_FAILED"
 	}
 
 	party.Members.Remove(player)
-	r.parties[party.ID] = *party
-	log.Printf("PlayerID %s left party %s", player, party.ID)
+	party.Moderators.Remove(player)
+	r.mu.Lock()
+	delete(r.players, player)
+	r.dropInternal(party, player)
+	r.emitInternal(ctx, entry, PartyEvent{Type: EventMemberLeft, PartyID: party.ID, Player: ref(player), Reason: "left"})
+	r.mu.Unlock()
+	logger.InfoContext(ctx, "player left party", "party", party.ID, "player", player)
 
 	return true, ""
 }
 
-func (r *PartyRegistry) Promote(sender UUID, partyID UUID, player UUID) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+func (r *PartyRegistry) Promote(ctx context.Context, sender UUID, partyID UUID, player UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Promote",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.promote", sender.String(), partyID, player, audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if party.CurrentLeader != sender {
 		return false, "ERR_NOT_LEADER"
 	}
@@ -324,8 +644,8 @@ func (r *PartyRegistry) Promote(sender UUID, partyID UUID, player UUID) (success
 	if party.IsMember(player) {
 		party.Moderators.Add(player)
 		party.Members.Remove(player)
-		r.parties[partyID] = *party
-		err := r.nc.Publish(env.EnsurePrefixed("party.promote.notify.moderator"), msg)
+		r.emit(ctx, entry, PartyEvent{Type: EventModeratorAdded, PartyID: partyID, Player: ref(player), Actor: ref(sender)})
+		err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.promote.notify.moderator"), msg)
 		if err != nil {
 			return false, "ERR_BROADCAST_FAILED"
 		}
@@ -336,8 +656,9 @@ func (r *PartyRegistry) Promote(sender UUID, partyID UUID, player UUID) (success
 		party.CurrentLeader = player
 		party.Moderators.Remove(player)
 		party.Members.Add(currentLeader)
-		r.parties[partyID] = *party
-		err := r.nc.Publish(env.EnsurePrefixed("party.promote.notify.leader"), msg)
+		r.emit(ctx, entry, PartyEvent{Type: EventLeaderChanged, PartyID: partyID, Player: ref(player), Actor: ref(sender),
+			Reason: "promoted", Role: RoleMember})
+		err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.promote.notify.leader"), msg)
 		if err != nil {
 			return false, "ERR_BROADCAST_FAILED"
 		}
@@ -346,13 +667,19 @@ func (r *PartyRegistry) Promote(sender UUID, partyID UUID, player UUID) (success
 	return false, "ERR_ALREADY_LEADER"
 }
 
-func (r *PartyRegistry) Demote(sender UUID, partyID UUID, player UUID) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+func (r *PartyRegistry) Demote(ctx context.Context, sender UUID, partyID UUID, player UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Demote",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.demote", sender.String(), partyID, player, audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if party.CurrentLeader != sender {
 		return false, "ERR_NOT_LEADER"
 	}
@@ -372,8 +699,8 @@ func (r *PartyRegistry) Demote(sender UUID, partyID UUID, player UUID) (success
 	if party.IsModerator(player) {
 		party.Moderators.Remove(player)
 		party.Members.Add(player)
-		r.parties[partyID] = *party
-		err := r.nc.Publish(env.EnsurePrefixed("party.demote.notify"), msg)
+		r.emit(ctx, entry, PartyEvent{Type: EventModeratorRemoved, PartyID: partyID, Player: ref(player), Actor: ref(sender)})
+		err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.demote.notify"), msg)
 		if err != nil {
 			return false, "ERR_BROADCAST_FAILED"
 		}
@@ -382,13 +709,19 @@ func (r *PartyRegistry) Demote(sender UUID, partyID UUID, player UUID) (success
 	return false, "ERR_ALREADY_LEADER"
 }
 
-func (r *PartyRegistry) Kick(sender UUID, partyID UUID, player UUID) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+func (r *PartyRegistry) Kick(ctx context.Context, sender UUID, partyID UUID, player UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Kick",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.kick", sender.String(), partyID, player, audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if party.CurrentLeader != sender && !party.IsModerator(sender) {
 		return false, "ERR_NO_KICK_PERMISSION"
 	}
@@ -407,26 +740,144 @@ func (r *PartyRegistry) Kick(sender UUID, partyID UUID, player UUID) (success bo
 		PlayerID: player,
 		SenderID: sender,
 	})
-	_ = r.nc.Publish(env.EnsurePrefixed("party.kick.notify"), msg)
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.kick.notify"), msg)
 
 	party.Members.Remove(player)
 	party.Moderators.Remove(player)
-	r.parties[partyID] = *party
+	var until *time.Time
+	if cooldown := config.Get().Parties.KickCooldown.D(); cooldown > 0 {
+		now := time.Now().UTC()
+		if party.KickCooldowns == nil {
+			party.KickCooldowns = make(map[UUID]time.Time)
+		}
+		party.pruneCooldowns(now)
+		expires := now.Add(cooldown)
+		party.KickCooldowns[player] = expires
+		until = &expires
+	}
+	r.mu.Lock()
+	delete(r.players, player)
+	r.dropInternal(party, player)
+	r.emitInternal(ctx, entry, PartyEvent{Type: EventMemberKicked, PartyID: partyID, Player: ref(player), Actor: ref(sender), Until: until})
+	r.mu.Unlock()
 
 	if party.TotalSize() <= 1 {
-		r.disbandForEmptyInternal(partyID)
+		r.disbandForEmpty(ctx, entry)
 	}
 
 	return true, ""
 }
 
-func (r *PartyRegistry) Transfer(sender UUID, partyID UUID, player UUID) (success bool, error string) {
+// Ban removes the player from the party, if they are in it, and keeps them from joining or being invited
+func (r *PartyRegistry) Ban(ctx context.Context, sender UUID, partyID UUID, player UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Ban",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.ban", sender.String(), partyID, player, audit.Result(success, error), nil)
+	}()
+	// deferred before the party is locked, so the listeners run after it's unlocked again
+	defer func() {
+		if !success {
+			return
+		}
+		r.mu.Lock()
+		listeners := slices.Clone(r.banListeners)
+		r.mu.Unlock()
+		for _, listener := range listeners {
+			listener(ctx, partyID, player)
+		}
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		return false, "ERR_INVALID_PARTY"
+	}
+	defer entry.mu.Unlock()
+	party := &entry.party
+	if party.CurrentLeader != sender && !party.IsModerator(sender) {
+		return false, "ERR_NO_PERMISSION"
+	}
+	if player == party.CurrentLeader {
+		return false, "ERR_CANNOT_BAN_LEADER"
+	}
+	if player == sender {
+		return false, "ERR_CANNOT_BAN_SELF"
+	}
+	if party.Bans.Contains(player) {
+		return false, "ERR_ALREADY_BANNED"
+	}
+
+	msg, _ := json.Marshal(&PartyTwoPlayerPacket{
+		PartyID:  partyID,
+		PlayerID: player,
+		SenderID: sender,
+	})
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.ban.notify"), msg)
+
+	wasInParty := party.IsInParty(player)
+	party.Members.Remove(player)
+	party.Moderators.Remove(player)
+	party.Bans.Add(player)
+	delete(party.KickCooldowns, player)
 	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+	if wasInParty {
+		delete(r.players, player)
+		r.dropInternal(party, player)
+	}
+	r.emitInternal(ctx, entry, PartyEvent{Type: EventPlayerBanned, PartyID: partyID, Player: ref(player), Actor: ref(sender)})
+	r.mu.Unlock()
+
+	if wasInParty && party.TotalSize() <= 1 {
+		r.disbandForEmpty(ctx, entry)
+	}
+	return true, ""
+}
+
+func (r *PartyRegistry) Unban(ctx context.Context, sender UUID, partyID UUID, player UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Unban",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.unban", sender.String(), partyID, player, audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		return false, "ERR_INVALID_PARTY"
+	}
+	defer entry.mu.Unlock()
+	party := &entry.party
+	if party.CurrentLeader != sender && !party.IsModerator(sender) {
+		return false, "ERR_NO_PERMISSION"
+	}
+	if !party.Bans.Contains(player) {
+		return false, "ERR_NOT_BANNED"
+	}
+
+	party.Bans.Remove(player)
+	r.emit(ctx, entry, PartyEvent{Type: EventPlayerUnbanned, PartyID: partyID, Player: ref(player), Actor: ref(sender)})
+
+	msg, _ := json.Marshal(&PartyTwoPlayerPacket{
+		PartyID:  partyID,
+		PlayerID: player,
+		SenderID: sender,
+	})
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.unban.notify"), msg)
+	return true, ""
+}
+
+func (r *PartyRegistry) Transfer(ctx context.Context, sender UUID, partyID UUID, player UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Transfer",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.transfer", sender.String(), partyID, player, audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if !party.IsInParty(player) {
 		return false, "ERR_TARGET_NOT_IN_PARTY"
 	}
@@ -437,25 +888,81 @@ func (r *PartyRegistry) Transfer(sender UUID, partyID UUID, player UUID) (succes
 	party.Moderators.Remove(player)
 	party.Members.Remove(player)
 	party.CurrentLeader = player
-	r.parties[partyID] = *party
+	r.emit(ctx, entry, PartyEvent{Type: EventLeaderChanged, PartyID: partyID, Player: ref(player), Actor: ref(sender),
+		Reason: "transferred", Role: RoleModerator})
 
 	msg, _ := json.Marshal(&PartyTwoPlayerPacket{
 		PartyID:  partyID,
 		PlayerID: player,
 		SenderID: sender,
 	})
-	_ = r.nc.Publish(env.EnsurePrefixed("party.transfer.notify.command"), msg)
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.transfer.notify.command"), msg)
 
 	return true, ""
 }
 
-func (r *PartyRegistry) ToggleMute(sender UUID, partyID UUID, state bool) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+// MuteMember keeps a player from using party chat, or lets them again. Moderators may only mute members.
+func (r *PartyRegistry) MuteMember(ctx context.Context, sender UUID, partyID UUID, player UUID, state bool) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.MuteMember",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID), attribute.Stringer("cydian.player_id", player))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.mute_member", sender.String(), partyID, player, audit.Result(success, error),
+			map[string]any{"state": state})
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		return false, "ERR_INVALID_PARTY"
+	}
+	defer entry.mu.Unlock()
+	party := &entry.party
+	if party.CurrentLeader != sender && !party.IsModerator(sender) {
+		return false, "ERR_NO_PERMISSION"
+	}
+	if !party.IsInParty(player) {
+		return false, "ERR_TARGET_NOT_IN_PARTY"
+	}
+	if player == party.CurrentLeader {
+		return false, "ERR_CANNOT_MUTE_LEADER"
+	}
+	if party.IsModerator(player) && party.CurrentLeader != sender {
+		return false, "ERR_NO_PERMISSION"
+	}
+	if party.MutedMembers.Contains(player) == state {
+		return false, "ERR_ALREADY_STATE"
+	}
+
+	if state {
+		party.MutedMembers.Add(player)
+	} else {
+		party.MutedMembers.Remove(player)
+	}
+	r.emit(ctx, entry, PartyEvent{Type: EventMemberMuted, PartyID: partyID, Player: ref(player), Actor: ref(sender), State: &state})
+
+	msg, _ := json.Marshal(&PartyMemberMutePacket{
+		PartyID:  partyID,
+		SenderID: sender,
+		PlayerID: player,
+		State:    state,
+	})
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.mute_member.notify"), msg)
+	return true, ""
+}
+
+func (r *PartyRegistry) ToggleMute(ctx context.Context, sender UUID, partyID UUID, state bool) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.ToggleMute",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.state.mute", sender.String(), partyID, UUID(uuid.Nil), audit.Result(success, error),
+			map[string]any{"state": state})
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if party.CurrentLeader != sender {
 		return false, "ERR_NO_PERMISSION"
 	}
@@ -465,24 +972,32 @@ func (r *PartyRegistry) ToggleMute(sender UUID, partyID UUID, state bool) (succe
 	}
 
 	party.Muted = state
-	r.parties[partyID] = *party
+	r.emit(ctx, entry, PartyEvent{Type: EventSettingChanged, PartyID: partyID, Actor: ref(sender), Setting: SettingMuted, State: &state})
 
 	msg, _ := json.Marshal(&PartyStateChangePacket{
 		PartyID:  partyID,
 		PlayerID: sender,
 		State:    state,
 	})
-	_ = r.nc.Publish(env.EnsurePrefixed("party.state.mute.notify"), msg)
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.state.mute.notify"), msg)
 	return true, ""
 }
 
-func (r *PartyRegistry) Yoink(sender UUID, partyID UUID) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+func (r *PartyRegistry) Yoink(ctx context.Context, sender UUID, partyID UUID) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.Yoink",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	// the target is the leader the party was taken from
+	oldLeader := UUID(uuid.Nil)
+	defer func() {
+		record(ctx, "party.yoink", sender.String(), partyID, oldLeader, audit.Result(success, error), nil)
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if !party.IsInParty(sender) {
 		return false, "ERR_NOT_IN_PARTY"
 	}
@@ -490,31 +1005,39 @@ func (r *PartyRegistry) Yoink(sender UUID, partyID UUID) (success bool, error st
 		return false, "ERR_ALREADY_LEADER"
 	}
 
-	oldLeader := party.CurrentLeader
+	oldLeader = party.CurrentLeader
 	party.Moderators.Remove(sender)
 	party.Members.Remove(sender)
 
 	party.CurrentLeader = sender
 	party.Moderators.Add(oldLeader)
 
-	r.parties[partyID] = *party
+	r.emit(ctx, entry, PartyEvent{Type: EventLeaderChanged, PartyID: partyID, Player: ref(sender), Actor: ref(sender),
+		Reason: "yoinked", Role: RoleModerator})
 
 	msg, _ := json.Marshal(&PartyOnePlayerPacket{
 		PartyID:  partyID,
 		PlayerID: sender,
 	})
-	_ = r.nc.Publish(env.EnsurePrefixed("party.yoink.notify"), msg)
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.yoink.notify"), msg)
 
 	return true, ""
 }
 
-func (r *PartyRegistry) ToggleOpenInvites(sender UUID, partyID UUID, state bool) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+func (r *PartyRegistry) ToggleOpenInvites(ctx context.Context, sender UUID, partyID UUID, state bool) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.ToggleOpenInvites",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.state.open_invites", sender.String(), partyID, UUID(uuid.Nil), audit.Result(success, error),
+			map[string]any{"state": state})
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if party.CurrentLeader != sender {
 		return false, "ERR_NO_PERMISSION"
 	}
@@ -524,24 +1047,31 @@ func (r *PartyRegistry) ToggleOpenInvites(sender UUID, partyID UUID, state bool)
 	}
 
 	party.OpenInvites = state
-	r.parties[partyID] = *party
+	r.emit(ctx, entry, PartyEvent{Type: EventSettingChanged, PartyID: partyID, Actor: ref(sender), Setting: SettingOpenInvites, State: &state})
 
 	msg, _ := json.Marshal(&PartyStateChangePacket{
 		PartyID:  partyID,
 		PlayerID: sender,
 		State:    state,
 	})
-	_ = r.nc.Publish(env.EnsurePrefixed("party.state.open_invites.notify"), msg)
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.state.open_invites.notify"), msg)
 	return true, ""
 }
 
-func (r *PartyRegistry) ToggleOpen(sender UUID, partyID UUID, state bool) (success bool, error string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	party := r.getPartyInternal(partyID)
-	if party == nil {
+func (r *PartyRegistry) ToggleOpen(ctx context.Context, sender UUID, partyID UUID, state bool) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.ToggleOpen",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.state.open", sender.String(), partyID, UUID(uuid.Nil), audit.Result(success, error),
+			map[string]any{"state": state})
+	}()
+	entry := r.lockParty(partyID)
+	if entry == nil {
 		return false, "ERR_INVALID_PARTY"
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 	if party.CurrentLeader != sender {
 		return false, "ERR_NO_PERMISSION"
 	}
@@ -551,156 +1081,342 @@ func (r *PartyRegistry) ToggleOpen(sender UUID, partyID UUID, state bool) (succe
 	}
 
 	party.Open = state
-	r.parties[partyID] = *party
+	r.emit(ctx, entry, PartyEvent{Type: EventSettingChanged, PartyID: partyID, Actor: ref(sender), Setting: SettingOpen, State: &state})
 
 	msg, _ := json.Marshal(&PartyStateChangePacket{
 		PartyID:  partyID,
 		PlayerID: sender,
 		State:    state,
 	})
-	_ = r.nc.Publish(env.EnsurePrefixed("party.state.open.notify"), msg)
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.state.open.notify"), msg)
 	return true, ""
 }
 
-func (r *PartyRegistry) selectNewLeader(party Party) UUID {
-	if party.Moderators.Size() != 0 {
-		return party.Moderators.Slice()[0]
+func (r *PartyRegistry) GetParty(id UUID) *Party {
+	entry := r.lockParty(id)
+	if entry == nil {
+		return nil
+	}
+	defer entry.mu.Unlock()
+	party := entry.party.Clone()
+	return &party
+}
+
+// FindParty returns the party with the ID, or the party of the player, whichever is given
+func (r *PartyRegistry) FindParty(partyID *UUID, playerID *UUID) *Party {
+	if partyID != nil {
+		return r.GetParty(*partyID)
 	}
-	if party.Members.Size() != 0 {
-		return party.Members.Slice()[0]
+	if playerID != nil {
+		_, party := r.GetPlayerParty(*playerID)
+		return party
 	}
-	return UUID(uuid.Nil)
+	return nil
 }
 
-func (r *PartyRegistry) GetParty(id UUID) *Party {
+// Sync returns the parties changed after the version and the ones disbanded since. Every party is
+// returned instead when the version is from another epoch, or predates a restart or the oldest
+// remembered disband.
+func (r *PartyRegistry) Sync(epoch UUID, since uint64) PartySyncResponse {
 	r.mu.Lock()
-	defer r.mu.Unlock()
-	return r.getPartyInternal(id)
+	r.pruneTombstonesInternal()
+
+	response := PartySyncResponse{
+		Version: r.sequence,
+		Epoch:   r.epoch,
+		Full:    epoch != r.epoch || since == 0 || since < r.syncFloor || since > r.sequence,
+		Parties: make([]Party, 0),
+		Removed: make([]UUID, 0),
+	}
+	if !response.Full {
+		for id, t := range r.tombstones {
+			if t.sequence > since {
+				response.Removed = append(response.Removed, id)
+			}
+		}
+	}
+	entries := r.entriesInternal()
+	r.mu.Unlock()
+
+	// parties changing meanwhile are returned with a version above the response's, and again on the next sync
+	for _, entry := range entries {
+		entry.mu.Lock()
+		if !entry.removed && (response.Full || entry.party.Version > since) {
+			response.Parties = append(response.Parties, entry.party.Clone())
+		}
+		entry.mu.Unlock()
+	}
+	return response
 }
 
-func (r *PartyRegistry) getPartyInternal(id UUID) *Party {
-	party, ok := r.parties[id]
-	if !ok {
-		return nil
+func (r *PartyRegistry) pruneTombstonesInternal() {
+	cutoff := time.Now().Add(-config.Get().Parties.TombstoneRetention.D())
+	for id, t := range r.tombstones {
+		if t.at.Before(cutoff) {
+			delete(r.tombstones, id)
+			r.syncFloor = max(r.syncFloor, t.sequence)
+		}
 	}
-	return &party
 }
 
 func (r *PartyRegistry) GetAllParties() []Party {
 	r.mu.Lock()
-	defer r.mu.Unlock()
-	values := make([]Party, 0, len(r.parties))
+	entries := r.entriesInternal()
+	r.mu.Unlock()
 
-	for _, v := range r.parties {
-		values = append(values, v)
+	values := make([]Party, 0, len(entries))
+	for _, entry := range entries {
+		entry.mu.Lock()
+		if !entry.removed {
+			values = append(values, entry.party.Clone())
+		}
+		entry.mu.Unlock()
 	}
 	return values
 }
 
-func (r *PartyRegistry) contains(invite Party) bool {
+func (r *PartyRegistry) entriesInternal() []*partyEntry {
+	entries := make([]*partyEntry, 0, len(r.parties))
+	for _, entry := range r.parties {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// SizeCounts returns how many parties there are of every size. It only reads the player index, instead of
+// locking and copying every party like GetAllParties, so a join in progress may already be counted.
+func (r *PartyRegistry) SizeCounts() map[int]int {
+	r.mu.Lock()
+	sizes := make(map[UUID]int, len(r.parties))
+	for _, partyID := range r.players {
+		sizes[partyID]++
+	}
+	r.mu.Unlock()
+
+	counts := make(map[int]int)
+	for _, size := range sizes {
+		counts[size]++
+	}
+	return counts
+}
+
+// PlayerPartyID returns the ID of the player's party, without copying the party like GetPlayerParty
+func (r *PartyRegistry) PlayerPartyID(player UUID) (UUID, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.containsKeyInternal(invite.ID)
+	id, ok := r.players[player]
+	return id, ok
 }
 
 // IsInParty checks if the player identified by the given UUID is a member of any party in the registry.
 func (r *PartyRegistry) IsInParty(player UUID) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.isInPartyInternal(player)
+	_, ok := r.players[player]
+	return ok
 }
 
-func (r *PartyRegistry) isInPartyInternal(player UUID) bool {
-	for _, party := range r.parties {
-		if party.IsInParty(player) {
-			return true
-		}
+func (r *PartyRegistry) GetPlayerParty(playerID UUID) (bool, *Party) {
+	entry := r.lockPlayerParty(playerID)
+	if entry == nil {
+		return false, nil
 	}
-	return false
+	defer entry.mu.Unlock()
+	party := entry.party.Clone()
+	return true, &party
 }
 
-func (r *PartyRegistry) GetPlayerParty(playerID UUID) (bool, *Party) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	return r.getPlayerPartyInternal(playerID)
+// removePartyInternal marks the entry removed, deletes the party and drops its players from the index.
+// Both the entry's lock and r.mu must be held.
+func (r *PartyRegistry) removePartyInternal(entry *partyEntry) {
+	party := entry.party
+	entry.removed = true
+	delete(r.players, party.CurrentLeader)
+	for _, player := range party.Moderators.Slice() {
+		delete(r.players, player)
+	}
+	for _, player := range party.Members.Slice() {
+		delete(r.players, player)
+	}
+	for player := range party.Offline {
+		r.disconnects.cancel(player)
+	}
+	delete(r.parties, party.ID)
 }
 
-func (r *PartyRegistry) getPlayerPartyInternal(playerID UUID) (bool, *Party) {
-	for id, party := range r.parties {
-		if party.IsInParty(playerID) {
-			p := r.parties[id]
-			return true, &p
-		}
-	}
-	return false, nil
+// dropInternal drops the tracked state and removal timer of a player leaving the party.
+// Both the entry's lock and r.mu must be held.
+func (r *PartyRegistry) dropInternal(party *Party, player UUID) {
+	party.drop(player)
+	r.disconnects.cancel(player)
 }
 
 func (r *PartyRegistry) containsKey(id UUID) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.containsKeyInternal(id)
-}
-
-func (r *PartyRegistry) containsKeyInternal(id UUID) bool {
 	_, ok := r.parties[id]
 	return ok
 }
 
-func (r *PartyRegistry) HandleDisconnect(playerID UUID) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if !r.isInPartyInternal(playerID) {
+// HandleDisconnect marks the player offline and removes them from their party once the grace period of
+// their role passes, unless they reconnect first. A disconnecting leader may hand leadership off right away.
+func (r *PartyRegistry) HandleDisconnect(ctx context.Context, playerID UUID) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.HandleDisconnect", attribute.Stringer("cydian.player_id", playerID))
+	defer span.End()
+	entry := r.lockPlayerParty(playerID)
+	if entry == nil {
 		return
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
+	if !party.IsOnline(playerID) {
+		return // already waiting out their grace period
+	}
 
-	_, party := r.getPlayerPartyInternal(playerID)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	r.disconnects[playerID] = cancel
-
-	// Start removal timer in goroutine
-	go func() {
-		select {
-		case <-time.After(5 * time.Minute):
-			r.DisconnectFromParty(playerID)
-		case <-ctx.Done():
-			fmt.Printf("Removal cancelled for player %s\n", playerID)
-			return
-		}
-	}()
+	grace := disconnectGrace(*party, playerID)
+	deadline := time.Now().UTC().Add(grace)
+	party.setOffline(playerID, deadline)
+	r.mu.Lock()
+	// the request's span has ended by the time the grace period is up
+	detached := context.WithoutCancel(ctx)
+	r.disconnects.scheduleGeneration(playerID, grace, func(generation uint64) {
+		r.removeDisconnected(detached, playerID, generation)
+	})
+	r.emitInternal(ctx, entry, PartyEvent{Type: EventMemberOffline, PartyID: party.ID, Player: ref(playerID), Until: &deadline})
+	r.mu.Unlock()
+	record(ctx, "party.disconnect", audit.ActorSystem, party.ID, playerID, audit.ResultSuccess, map[string]any{"grace": grace.String()})
 
 	msg, _ := json.Marshal(&PartyOnePlayerPacket{
 		PlayerID: playerID,
 		PartyID:  party.ID,
 	})
-	err := r.nc.Publish(env.EnsurePrefixed("party.status.disconnect"), msg)
+	err := utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.status.disconnect"), msg)
 	if err != nil {
-		log.Printf("Failed to broadcast party remove disconnected: %v", err)
+		logger.ErrorContext(ctx, "failed to broadcast party disconnect", "party", party.ID, "player", playerID, "err", err)
 	}
 
-	log.Printf("PlayerID %s disconnected, will be removed in 5 minutes\n", playerID)
+	logger.InfoContext(ctx, "party member disconnected", "party", party.ID, "player", playerID, "grace", grace)
+
+	if party.CurrentLeader == playerID && config.Get().Parties.LeaderOfflineTransfer {
+		r.handOffLeadership(ctx, entry, playerID)
+	}
+}
+
+// disconnectGrace returns how long the player may stay offline before being removed, by their role
+func disconnectGrace(party Party, player UUID) time.Duration {
+	parties := config.Get().Parties
+	role := RoleMember
+	if party.CurrentLeader == player {
+		role = RoleLeader
+	} else if party.IsModerator(player) {
+		role = RoleModerator
+	}
+	if grace, ok := parties.RoleDisconnectGrace[role]; ok {
+		return grace.D()
+	}
+	return parties.DisconnectGrace.D()
 }
 
-func (r *PartyRegistry) HandleReconnect(playerID UUID) {
+// handOffLeadership makes an online player the leader in place of the offline one, who becomes a
+// moderator. Nothing changes when no one else is online. The entry's lock must be held.
+func (r *PartyRegistry) handOffLeadership(ctx context.Context, entry *partyEntry, leader UUID) {
+	party := &entry.party
+	newLeader := r.selectNewLeader(*party)
+	if newLeader == UUID(uuid.Nil) {
+		return
+	}
+
+	party.CurrentLeader = newLeader
+	party.Members.Remove(newLeader)
+	party.Moderators.Remove(newLeader)
+	party.Moderators.Add(leader)
+	party.DisplacedLeader = ref(leader)
+	r.emit(ctx, entry, PartyEvent{Type: EventLeaderChanged, PartyID: party.ID, Player: ref(newLeader), Reason: "offline", Role: RoleModerator})
+	record(ctx, "party.transfer.offline", audit.ActorSystem, party.ID, newLeader, audit.ResultSuccess, nil)
+
+	msg, _ := json.Marshal(&PartyTwoPlayerPacket{
+		PartyID:  party.ID,
+		SenderID: leader,
+		PlayerID: newLeader,
+	})
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.transfer.notify.offline"), msg)
+	logger.InfoContext(ctx, "party leadership handed off from offline leader", "party", party.ID, "leader", leader, "new_leader", newLeader)
+}
+
+// PendingDisconnects returns how many disconnected players are waiting to be removed from their party
+func (r *PartyRegistry) PendingDisconnects() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return len(r.disconnects)
+}
 
-	// Cancel the removal timer if it exists
-	if cancel, exists := r.disconnects[playerID]; exists {
-		cancel()
-		delete(r.disconnects, playerID)
-		log.Printf("PlayerID %s reconnected, removal cancelled\n", playerID)
+// HandleReconnect cancels the player's removal. A leader who lost leadership by disconnecting gets it
+// back when parties.restore_leader is set.
+func (r *PartyRegistry) HandleReconnect(ctx context.Context, playerID UUID) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.HandleReconnect", attribute.Stringer("cydian.player_id", playerID))
+	defer span.End()
+	entry := r.lockPlayerParty(playerID)
+	if entry == nil {
+		return
 	}
+	defer entry.mu.Unlock()
+	party := &entry.party
 
-	if !r.isInPartyInternal(playerID) {
-		return
+	displaced := party.DisplacedLeader != nil && *party.DisplacedLeader == playerID
+	if !party.IsOnline(playerID) {
+		r.mu.Lock()
+		party.forget(playerID)
+		r.disconnects.cancel(playerID)
+		r.emitInternal(ctx, entry, PartyEvent{Type: EventMemberOnline, PartyID: party.ID, Player: ref(playerID)})
+		r.mu.Unlock()
+		record(ctx, "party.reconnect", audit.ActorSystem, party.ID, playerID, audit.ResultSuccess, nil)
+		logger.InfoContext(ctx, "party member reconnected, removal cancelled", "player", playerID)
 	}
 
-	_, party := r.getPlayerPartyInternal(playerID)
 	msg, _ := json.Marshal(&PartyOnePlayerPacket{
 		PartyID:  party.ID,
 		PlayerID: playerID,
 	})
-	_ = r.nc.Publish(env.EnsurePrefixed("party.status.reconnect"), msg)
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.status.reconnect"), msg)
+
+	if displaced && party.CurrentLeader != playerID && config.Get().Parties.RestoreLeader {
+		r.restoreLeadership(ctx, entry, playerID)
+	}
+}
+
+// restoreLeadership gives the displaced leader their party back. The leader in the meantime becomes a
+// moderator. The entry's lock must be held.
+func (r *PartyRegistry) restoreLeadership(ctx context.Context, entry *partyEntry, leader UUID) {
+	party := &entry.party
+	previous := party.CurrentLeader
+	party.Members.Remove(leader)
+	party.Moderators.Remove(leader)
+	party.CurrentLeader = leader
+	party.Moderators.Add(previous)
+	r.emit(ctx, entry, PartyEvent{Type: EventLeaderChanged, PartyID: party.ID, Player: ref(leader), Reason: "reconnected", Role: RoleModerator})
+	record(ctx, "party.transfer.restore", audit.ActorSystem, party.ID, leader, audit.ResultSuccess, nil)
+
+	msg, _ := json.Marshal(&PartyTwoPlayerPacket{
+		PartyID:  party.ID,
+		SenderID: previous,
+		PlayerID: leader,
+	})
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.transfer.notify.reconnected"), msg)
+	logger.InfoContext(ctx, "party leadership restored to reconnected leader", "party", party.ID, "leader", leader)
+}
+
+// record adds a party operation to the audit trail. A nil target or party is left out of the entry.
+func record(ctx context.Context, action string, actor string, partyID UUID, target UUID, result string, params map[string]any) {
+	entry := audit.Entry{Actor: actor, Action: action, Result: result, Params: params}
+	if partyID != UUID(uuid.Nil) {
+		entry.Party = partyID.String()
+	}
+	if target != UUID(uuid.Nil) {
+		entry.Target = target.String()
+	}
+	audit.Record(ctx, entry)
+}
+
+func ref(id UUID) *UUID {
+	return &id
 }
@@ -0,0 +1,460 @@
+package parties
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// newTestRegistry returns an empty registry publishing to an in-process NATS server
+func newTestRegistry(tb testing.TB) *PartyRegistry {
+	tb.Helper()
+	if err := logging.Setup(io.Discard, "text", "error"); err != nil {
+		tb.Fatal(err)
+	}
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		tb.Fatal("nats server did not start")
+	}
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		nc.Close()
+		s.Shutdown()
+	})
+	return NewPartyRegistry(nc)
+}
+
+func newID() UUID {
+	return UUID(uuid.New())
+}
+
+// seedParties creates open parties of a leader and one member, returning the party IDs and their members
+func seedParties(tb testing.TB, r *PartyRegistry, count int) (parties []UUID, members []UUID) {
+	tb.Helper()
+	ctx := context.Background()
+	parties = make([]UUID, count)
+	members = make([]UUID, count)
+	for i := range count {
+		leader, partyID, member := newID(), newID(), newID()
+		r.CreateParty(ctx, partyID, leader, nil)
+		if ok, code := r.ToggleOpen(ctx, leader, partyID, true); !ok {
+			tb.Fatalf("opening party: %s", code)
+		}
+		if ok, code := r.JoinParty(ctx, partyID, member, JoinOpen); !ok {
+			tb.Fatalf("joining party: %s", code)
+		}
+		parties[i], members[i] = partyID, member
+	}
+	return parties, members
+}
+
+var benchmarkSizes = []int{1_000, 10_000, 100_000}
+
+// benchmarkRegistries runs fn against registries of 1k, 10k and 100k parties, so lookups can be
+// compared across sizes
+func benchmarkRegistries(b *testing.B, fn func(b *testing.B, r *PartyRegistry, parties []UUID, members []UUID)) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("parties=%d", size), func(b *testing.B) {
+			r := newTestRegistry(b)
+			parties, members := seedParties(b, r, size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			fn(b, r, parties, members)
+		})
+	}
+}
+
+func BenchmarkPartyRegistryGetParty(b *testing.B) {
+	benchmarkRegistries(b, func(b *testing.B, r *PartyRegistry, parties []UUID, _ []UUID) {
+		for i := 0; b.Loop(); i++ {
+			if r.GetParty(parties[i%len(parties)]) == nil {
+				b.Fatal("party not found")
+			}
+		}
+	})
+}
+
+func BenchmarkPartyRegistryGetPlayerParty(b *testing.B) {
+	benchmarkRegistries(b, func(b *testing.B, r *PartyRegistry, _ []UUID, members []UUID) {
+		for i := 0; b.Loop(); i++ {
+			if ok, _ := r.GetPlayerParty(members[i%len(members)]); !ok {
+				b.Fatal("player's party not found")
+			}
+		}
+	})
+}
+
+func BenchmarkPartyRegistryJoinParty(b *testing.B) {
+	benchmarkRegistries(b, func(b *testing.B, r *PartyRegistry, parties []UUID, _ []UUID) {
+		ctx := context.Background()
+		for i := 0; b.Loop(); i++ {
+			player := newID()
+			if ok, code := r.JoinParty(ctx, parties[i%len(parties)], player, JoinOpen); !ok {
+				b.Fatalf("joining party: %s", code)
+			}
+			// leave again off the clock, so parties never fill up
+			b.StopTimer()
+			if ok, code := r.LeaveParty(ctx, player); !ok {
+				b.Fatalf("leaving party: %s", code)
+			}
+			b.StartTimer()
+		}
+	})
+}
+
+// TestPartyRegistryConcurrentChanges races joins, kicks, transfers, leaves and disbands across a shared pool
+// of players, then checks the registry is still consistent. Run it with -race.
+func TestPartyRegistryConcurrentChanges(t *testing.T) {
+	r := newTestRegistry(t)
+	ctx := context.Background()
+
+	players := make([]UUID, 64)
+	for i := range players {
+		players[i] = newID()
+	}
+	var mu sync.Mutex
+	var parties []UUID
+	createParty := func() {
+		partyID, leader := newID(), newID()
+		r.CreateParty(ctx, partyID, leader, nil)
+		r.ToggleOpen(ctx, leader, partyID, true)
+		mu.Lock()
+		parties = append(parties, partyID)
+		mu.Unlock()
+	}
+	randomParty := func(rng *rand.Rand) UUID {
+		mu.Lock()
+		defer mu.Unlock()
+		return parties[rng.IntN(len(parties))]
+	}
+	// randomInParty returns someone in the party, other than its leader when possible
+	randomInParty := func(rng *rand.Rand, party *Party) UUID {
+		others := append(party.Moderators.Slice(), party.Members.Slice()...)
+		if len(others) == 0 {
+			return party.CurrentLeader
+		}
+		return others[rng.IntN(len(others))]
+	}
+	for range 8 {
+		createParty()
+	}
+
+	for round := range 5 {
+		var wg sync.WaitGroup
+		for worker := range 16 {
+			wg.Go(func() {
+				rng := rand.New(rand.NewPCG(uint64(round), uint64(worker)))
+				for range 400 {
+					partyID := randomParty(rng)
+					party := r.GetParty(partyID)
+					switch op := rng.IntN(10); {
+					case op < 4:
+						r.JoinParty(ctx, partyID, players[rng.IntN(len(players))], JoinOpen)
+					case op < 5:
+						r.LeaveParty(ctx, players[rng.IntN(len(players))])
+					case op < 6 && party != nil:
+						r.Kick(ctx, party.CurrentLeader, partyID, randomInParty(rng, party))
+					case op < 8 && party != nil:
+						r.Transfer(ctx, party.CurrentLeader, partyID, randomInParty(rng, party))
+					case op < 9 && party != nil:
+						r.Disband(ctx, partyID, party.CurrentLeader)
+					default:
+						createParty()
+					}
+				}
+			})
+		}
+		wg.Wait()
+		checkRegistryInvariants(t, r)
+	}
+}
+
+// checkRegistryInvariants fails the test unless every player is in at most one party, the player index
+// matches the parties, and every party has a leader who isn't also listed as a member or moderator
+func checkRegistryInvariants(t *testing.T, r *PartyRegistry) {
+	t.Helper()
+	// nothing is changing, but take the locks in the registry's order anyway: parties before r.mu
+	r.mu.Lock()
+	entries := maps.Clone(r.parties)
+	index := maps.Clone(r.players)
+	r.mu.Unlock()
+
+	seen := make(map[UUID]UUID)
+	for id, entry := range entries {
+		entry.mu.Lock()
+		party := entry.party.Clone()
+		removed := entry.removed
+		entry.mu.Unlock()
+
+		if removed {
+			t.Errorf("party %s was removed but is still registered", id)
+		}
+		if party.CurrentLeader == UUID(uuid.Nil) || !party.IsInParty(party.CurrentLeader) {
+			t.Errorf("party %s has no leader", id)
+		}
+		if party.Members.Contains(party.CurrentLeader) || party.Moderators.Contains(party.CurrentLeader) {
+			t.Errorf("party %s lists its leader %s as a member or moderator", id, party.CurrentLeader)
+		}
+		everyone := append([]UUID{party.CurrentLeader}, append(party.Moderators.Slice(), party.Members.Slice()...)...)
+		for _, player := range everyone {
+			if other, ok := seen[player]; ok && other != id {
+				t.Errorf("player %s is in parties %s and %s", player, other, id)
+			}
+			seen[player] = id
+			if indexed, ok := index[player]; !ok || indexed != id {
+				t.Errorf("player %s is in party %s but indexed to %s", player, id, indexed)
+			}
+		}
+		for _, member := range party.Members.Slice() {
+			if party.Moderators.Contains(member) {
+				t.Errorf("player %s is both a member and a moderator of party %s", member, id)
+			}
+		}
+	}
+	for player, id := range index {
+		if seen[player] != id {
+			t.Errorf("player %s is indexed to party %s but isn't in it", player, id)
+		}
+	}
+
+	sizes := make(map[int]int)
+	for _, party := range r.GetAllParties() {
+		sizes[party.TotalSize()]++
+	}
+	if counts := r.SizeCounts(); !maps.Equal(counts, sizes) {
+		t.Errorf("size counts %v don't match the parties' sizes %v", counts, sizes)
+	}
+}
+
+// TestPartyRegistrySyncAcrossRestart checks a version from before a restart gets a full sync, even once the
+// new registry's sequence has passed it
+func TestPartyRegistrySyncAcrossRestart(t *testing.T) {
+	before := newTestRegistry(t)
+	seedParties(t, before, 2)
+	synced := before.Sync(UUID(uuid.Nil), 0)
+	if !synced.Full || len(synced.Parties) != 2 {
+		t.Fatalf("first sync should be full with 2 parties, got full=%v with %d", synced.Full, len(synced.Parties))
+	}
+	if changes := before.Sync(synced.Epoch, synced.Version); changes.Full || len(changes.Parties) != 0 {
+		t.Fatalf("sync at the latest version should be empty, got full=%v with %d parties", changes.Full, len(changes.Parties))
+	}
+
+	after := newTestRegistry(t)
+	seedParties(t, after, 4)
+	if after.Sync(synced.Epoch, synced.Version).Version <= synced.Version {
+		t.Fatal("the restarted registry's sequence should have passed the old version")
+	}
+	if resynced := after.Sync(synced.Epoch, synced.Version); !resynced.Full || len(resynced.Parties) != 4 {
+		t.Fatalf("sync from before the restart should be full with 4 parties, got full=%v with %d", resynced.Full, len(resynced.Parties))
+	}
+}
+
+// TestInviteRegistryDropsDeadInvites checks invites that can no longer be accepted stop holding a place in
+// their party, and that bans take the player's invites and join requests with them
+func TestInviteRegistryDropsDeadInvites(t *testing.T) {
+	r := newTestRegistry(t)
+	invites := NewInviteRegistry(r.nc, r)
+	joinRequests := NewJoinRequestRegistry(r.nc, r)
+	ctx := context.Background()
+
+	// accepting fails when the recipient joined another party meanwhile
+	leader, partyID, recipient := newID(), newID(), newID()
+	r.CreateParty(ctx, partyID, leader, nil)
+	invite, code := invites.CreateInvite(ctx, leader, partyID, recipient, "")
+	if invite == nil {
+		t.Fatalf("inviting: %s", code)
+	}
+	others, _ := seedParties(t, r, 1)
+	if ok, code := r.JoinParty(ctx, others[0], recipient, JoinOpen); !ok {
+		t.Fatalf("joining the other party: %s", code)
+	}
+	if accepted, code := invites.Accept(ctx, invite.ID); accepted != nil || code != "ERR_ALREADY_IN_PARTY" {
+		t.Fatalf("accepting should fail with ERR_ALREADY_IN_PARTY for a player already in a party, got %q", code)
+	}
+	if party := r.GetParty(partyID); party != nil {
+		t.Fatalf("the party should disband once its only invite is gone, it has %d invites", len(party.ActiveInvites))
+	}
+
+	// banning revokes the player's invites
+	partyID, member, banned := newID(), newID(), newID()
+	r.CreateParty(ctx, partyID, leader, nil)
+	second, code := invites.CreateInvite(ctx, leader, partyID, member, "")
+	if second == nil {
+		t.Fatalf("inviting: %s", code)
+	}
+	if accepted, code := invites.Accept(ctx, second.ID); accepted == nil {
+		t.Fatalf("accepting the invite: %s", code)
+	}
+	if invite, code := invites.CreateInvite(ctx, leader, partyID, banned, ""); invite == nil {
+		t.Fatalf("inviting: %s", code)
+	}
+	if request, code := joinRequests.Send(ctx, partyID, banned); request == nil {
+		t.Fatalf("requesting to join: %s", code)
+	}
+	if ok, code := r.Ban(ctx, leader, partyID, banned); !ok {
+		t.Fatalf("banning: %s", code)
+	}
+	if incoming := invites.Incoming(banned); len(incoming) != 0 {
+		t.Fatalf("banned player still has %d invites", len(incoming))
+	}
+	if requests := joinRequests.GetAll(); len(requests) != 0 {
+		t.Fatalf("banned player still has %d join requests", len(requests))
+	}
+	if party := r.GetParty(partyID); party == nil || len(party.ActiveInvites) != 0 {
+		t.Fatal("the party should still exist without the banned player's invite")
+	}
+}
+
+// recordedEvents is an EventLog keeping the events in memory
+type recordedEvents []PartyEvent
+
+func (e *recordedEvents) Append(event PartyEvent, _ []byte) {
+	*e = append(*e, event)
+}
+
+// TestJoinRequestApproval checks an approved join request lets the player into a closed party without
+// consuming their invite, and records the join as coming from the request
+func TestJoinRequestApproval(t *testing.T) {
+	r := newTestRegistry(t)
+	invites := NewInviteRegistry(r.nc, r)
+	joinRequests := NewJoinRequestRegistry(r.nc, r)
+	ctx := context.Background()
+	events := &recordedEvents{}
+	r.SetEventLog(ctx, events, 0)
+
+	leader, partyID, player := newID(), newID(), newID()
+	r.CreateParty(ctx, partyID, leader, nil)
+	invite, code := invites.CreateInvite(ctx, leader, partyID, player, "")
+	if invite == nil {
+		t.Fatalf("inviting: %s", code)
+	}
+	request, code := joinRequests.Send(ctx, partyID, player)
+	if request == nil {
+		t.Fatalf("requesting to join: %s", code)
+	}
+	if answered, code := joinRequests.Respond(ctx, request.ID, leader, true); answered == nil {
+		t.Fatalf("approving the request: %s", code)
+	}
+
+	party := r.GetParty(partyID)
+	if party == nil || !party.Members.Contains(player) {
+		t.Fatal("the approved player should be a member")
+	}
+	if _, ok := party.ActiveInvites[invite.ID]; !ok {
+		t.Fatal("approving the request should not consume the player's invite")
+	}
+	joined := (*events)[len(*events)-1]
+	if joined.Type != EventMemberJoined || joined.Reason != string(JoinRequest) {
+		t.Fatalf("the last event should be a join from the request, got %s (%s)", joined.Type, joined.Reason)
+	}
+}
+
+// TestPartyRegistryStaleDisconnectTimer checks a removal timer from an earlier disconnect can't remove a
+// player who reconnected and disconnected again
+func TestPartyRegistryStaleDisconnectTimer(t *testing.T) {
+	r := newTestRegistry(t)
+	ctx := context.Background()
+	parties, members := seedParties(t, r, 1)
+	extra := newID()
+	if ok, code := r.JoinParty(ctx, parties[0], extra, JoinOpen); !ok {
+		t.Fatalf("joining party: %s", code)
+	}
+	generation := func() uint64 {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.disconnects[members[0]].generation
+	}
+
+	r.HandleDisconnect(ctx, members[0])
+	stale := generation()
+	r.HandleReconnect(ctx, members[0])
+	r.HandleDisconnect(ctx, members[0])
+
+	r.removeDisconnected(ctx, members[0], stale)
+	if !r.IsInParty(members[0]) {
+		t.Fatal("a stale removal timer removed the player")
+	}
+	r.removeDisconnected(ctx, members[0], generation())
+	if r.IsInParty(members[0]) {
+		t.Fatal("the current removal timer didn't remove the player")
+	}
+}
+
+// TestPartyRegistryOfflineSuccessors checks offline players are never picked to lead, and a party with no
+// one online to lead it is disbanded when its leader leaves
+func TestPartyRegistryOfflineSuccessors(t *testing.T) {
+	r := newTestRegistry(t)
+	ctx := context.Background()
+	parties, members := seedParties(t, r, 1)
+	partyID, offline, online := parties[0], members[0], newID()
+	if ok, code := r.JoinParty(ctx, partyID, online, JoinOpen); !ok {
+		t.Fatalf("joining party: %s", code)
+	}
+	r.HandleDisconnect(ctx, offline)
+
+	party := r.GetParty(partyID)
+	if successors := party.successors(); len(successors) != 1 || successors[0] != online {
+		t.Fatalf("successors should only be the online member, got %v", successors)
+	}
+
+	r.HandleDisconnect(ctx, online)
+	if successors := r.GetParty(partyID).successors(); len(successors) != 0 {
+		t.Fatalf("successors should be empty with everyone offline, got %v", successors)
+	}
+	if ok, code := r.LeaveParty(ctx, party.CurrentLeader); !ok {
+		t.Fatalf("leaving party: %s", code)
+	}
+	if r.GetParty(partyID) != nil {
+		t.Fatal("the party should be disbanded when no one online can lead it")
+	}
+	if r.IsInParty(offline) || r.IsInParty(online) {
+		t.Fatal("the offline members should have left with the disbanded party")
+	}
+}
+
+// TestPartyRegistryConcurrentCreate checks an owner racing to create several parties ends up leading one
+func TestPartyRegistryConcurrentCreate(t *testing.T) {
+	r := newTestRegistry(t)
+	ctx := context.Background()
+	owner := newID()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for range 8 {
+		wg.Go(func() {
+			if party, code := r.CreateParty(ctx, newID(), owner, nil); party != nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else if code != "ERR_ALREADY_IN_PARTY" {
+				t.Errorf("creating party: %s", code)
+			}
+		})
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Fatalf("%d parties were created for one owner", created)
+	}
+	if len(r.GetAllParties()) != 1 {
+		t.Fatalf("the registry holds %d parties", len(r.GetAllParties()))
+	}
+	checkRegistryInvariants(t, r)
+}
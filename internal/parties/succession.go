@@ -0,0 +1,107 @@
+package parties
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/CytonicMC/Cydian/internal/audit"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Succession policies pick who leads a party after its leader leaves or goes offline. Only online players
+// are picked, and ties go to whoever joined the party earliest.
+const (
+	// SuccessionModerators picks the longest-tenured moderator, then the longest-tenured member
+	SuccessionModerators = "moderators"
+	// SuccessionTenure picks the longest-tenured player, moderator or not
+	SuccessionTenure = "tenure"
+)
+
+func validSuccession(policy string) bool {
+	return policy == SuccessionModerators || policy == SuccessionTenure
+}
+
+// successors orders the players who could lead the party next, by its succession policy, best first
+func (p Party) successors() []UUID {
+	moderatorsFirst := p.Succession != SuccessionTenure
+	rank := func(player UUID) int {
+		if moderatorsFirst && !p.IsModerator(player) {
+			return 1
+		}
+		return 0
+	}
+
+	// offline players can't lead, they may never come back
+	candidates := slices.DeleteFunc(append(p.Moderators.Slice(), p.Members.Slice()...), func(player UUID) bool {
+		return !p.IsOnline(player)
+	})
+	slices.SortFunc(candidates, func(a, b UUID) int {
+		if c := cmp.Compare(rank(a), rank(b)); c != 0 {
+			return c
+		}
+		if c := p.JoinedAt[a].Compare(p.JoinedAt[b]); c != 0 {
+			return c
+		}
+		return strings.Compare(a.String(), b.String())
+	})
+	return candidates
+}
+
+// selectNewLeader returns the party's first successor, or uuid.Nil if no one online is left to lead it
+func (r *PartyRegistry) selectNewLeader(party Party) UUID {
+	if successors := party.successors(); len(successors) != 0 {
+		return successors[0]
+	}
+	return UUID(uuid.Nil)
+}
+
+// SetSuccession changes how the party's next leader is picked. Only the leader may change it.
+func (r *PartyRegistry) SetSuccession(ctx context.Context, sender UUID, partyID UUID, policy string) (success bool, error string) {
+	ctx, span := tracing.Start(ctx, "PartyRegistry.SetSuccession",
+		attribute.Stringer("cydian.sender_id", sender), attribute.Stringer("cydian.party_id", partyID))
+	defer span.End()
+	defer func() {
+		record(ctx, "party.succession", sender.String(), partyID, UUID(uuid.Nil), audit.Result(success, error),
+			map[string]any{"policy": policy})
+	}()
+
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if !validSuccession(policy) {
+		return false, "ERR_INVALID_POLICY"
+	}
+
+	entry := r.lockParty(partyID)
+	if entry == nil {
+		return false, "ERR_INVALID_PARTY"
+	}
+	defer entry.mu.Unlock()
+	party := &entry.party
+	if party.CurrentLeader != sender {
+		return false, "ERR_NO_PERMISSION"
+	}
+	current := party.Succession
+	if current == "" {
+		current = SuccessionModerators
+	}
+	if current == policy {
+		return false, "ERR_ALREADY_STATE"
+	}
+
+	party.Succession = policy
+	r.emit(ctx, entry, PartyEvent{Type: EventSuccessionChanged, PartyID: partyID, Actor: ref(sender), Policy: policy})
+
+	msg, _ := json.Marshal(&PartySuccessionPacket{
+		PartyID:  partyID,
+		PlayerID: sender,
+		Policy:   policy,
+	})
+	_ = utils.Publish(ctx, r.nc, env.EnsurePrefixed("party.succession.update.notify"), msg)
+	return true, ""
+}
@@ -0,0 +1,74 @@
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Presence is an online player, as reported by the proxies
+type Presence struct {
+	UUID     uuid.UUID `json:"uuid"`
+	Username string    `json:"username"`
+	Rank     string    `json:"rank,omitempty"`
+	Server   string    `json:"server,omitempty"` // the ID of the server they are on, if known
+	Since    time.Time `json:"since"`
+}
+
+// Registry to store online players
+type Registry struct {
+	mu     sync.Mutex
+	online map[uuid.UUID]Presence
+}
+
+// NewRegistry creates a new Registry instance
+func NewRegistry() *Registry {
+	return &Registry{online: make(map[uuid.UUID]Presence)}
+}
+
+// Connect marks the player as online
+func (r *Registry) Connect(id uuid.UUID, username string, rank string, server string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.online[id] = Presence{UUID: id, Username: username, Rank: rank, Server: server, Since: time.Now()}
+}
+
+// SetServer records the server an online player moved to
+func (r *Registry) SetServer(id uuid.UUID, server string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.online[id]; ok {
+		p.Server = server
+		r.online[id] = p
+	}
+}
+
+// Disconnect marks the player as offline
+func (r *Registry) Disconnect(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.online, id)
+}
+
+// Get returns the player's presence, if they are online
+func (r *Registry) Get(id uuid.UUID) (*Presence, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.online[id]
+	if !ok {
+		return nil, false
+	}
+	return &p, true
+}
+
+// GetAll returns every online player
+func (r *Registry) GetAll() []Presence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := make([]Presence, 0, len(r.online))
+	for _, p := range r.online {
+		all = append(all, p)
+	}
+	return all
+}
@@ -0,0 +1,150 @@
+package schedule
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/cronexpr"
+	"go.yaml.in/yaml/v2"
+)
+
+// File is the on-disk schedule, keyed by server type (the Nomad job / task group name)
+//
+//	types:
+//	  lobby:
+//	    minimums:
+//	      - cron: "0 18 * * *"   # evenings
+//	        min: 6
+//	      - cron: "0 1 * * *"
+//	        min: 2
+//	    maintenance:
+//	      - cron: "0 4 * * 1"    # mondays at 04:00
+//	        duration: 30m
+//	        drain: 5m
+//	        message: "Weekly maintenance"
+type File struct {
+	Types map[string]TypeSchedule `yaml:"types" json:"types"`
+}
+
+type TypeSchedule struct {
+	Minimums    []Minimum `yaml:"minimums" json:"minimums"`
+	Maintenance []Window  `yaml:"maintenance" json:"maintenance"`
+}
+
+// Minimum sets the minimum instance count of a type from the time its cron expression fires
+// until the next Minimum of the same type fires.
+type Minimum struct {
+	Cron string `yaml:"cron" json:"cron"`
+	Min  int    `yaml:"min" json:"min"`
+
+	expr *cronexpr.Expression
+}
+
+// Window is a maintenance window. When it starts, the type is drained, then scaled to zero once
+// Drain has elapsed. It is restored to its scheduled minimum after Duration.
+type Window struct {
+	Cron     string `yaml:"cron" json:"cron"`
+	Duration string `yaml:"duration" json:"duration"`
+	Drain    string `yaml:"drain" json:"drain"`
+	Message  string `yaml:"message" json:"message"`
+
+	expr     *cronexpr.Expression
+	duration time.Duration
+	drain    time.Duration
+}
+
+// TypeState is the live state of a scheduled type, as reported by instances.schedule.get
+type TypeState struct {
+	Minimum         int        `json:"minimum"`
+	InMaintenance   bool       `json:"in_maintenance"`
+	MaintenanceEnds *time.Time `json:"maintenance_ends"`
+	NextMinimum     *time.Time `json:"next_minimum"`
+	NextMaintenance *time.Time `json:"next_maintenance"`
+	ScaledDownFrom  int        `json:"scaled_down_from"` // instances before the window, restored once it ends
+
+	scaleDownDue time.Time // when the window's drain ends, zero once the type was scaled down
+	scalingDown  bool      // a scale down is in progress
+}
+
+type ScheduleResponse struct {
+	Schedule File                 `json:"schedule"`
+	State    map[string]TypeState `json:"state"`
+}
+
+// Load reads and validates a schedule file
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	if err := file.parse(); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (f *File) parse() error {
+	for name, t := range f.Types {
+		for i := range t.Minimums {
+			m := &t.Minimums[i]
+			expr, err := cronexpr.Parse(m.Cron)
+			if err != nil {
+				return fmt.Errorf("type %s: minimum %d: %w", name, i, err)
+			}
+			if m.Min < 0 {
+				return fmt.Errorf("type %s: minimum %d: min cannot be negative", name, i)
+			}
+			m.expr = expr
+		}
+		for i := range t.Maintenance {
+			w := &t.Maintenance[i]
+			expr, err := cronexpr.Parse(w.Cron)
+			if err != nil {
+				return fmt.Errorf("type %s: maintenance %d: %w", name, i, err)
+			}
+			w.expr = expr
+			if w.duration, err = time.ParseDuration(w.Duration); err != nil || w.duration <= 0 {
+				return fmt.Errorf("type %s: maintenance %d: invalid duration %q", name, i, w.Duration)
+			}
+			if w.Drain != "" {
+				if w.drain, err = time.ParseDuration(w.Drain); err != nil || w.drain < 0 || w.drain > w.duration {
+					return fmt.Errorf("type %s: maintenance %d: invalid drain %q", name, i, w.Drain)
+				}
+			}
+		}
+		f.Types[name] = t
+	}
+	return nil
+}
+
+// fireCache remembers when each expression last fired, so lastFire only steps through the fires since
+// the previous evaluation instead of the whole lookback
+type fireCache map[*cronexpr.Expression]time.Time
+
+// lastFire returns the most recent time expr fired at or before now, looking back at most lookback
+func (c fireCache) lastFire(expr *cronexpr.Expression, now time.Time, lookback time.Duration) (time.Time, bool) {
+	var last time.Time
+	t := now.Add(-lookback)
+	if cached, ok := c[expr]; ok && cached.After(t) && !cached.After(now) {
+		last, t = cached, cached
+	}
+	for {
+		next := expr.Next(t)
+		if next.IsZero() || next.After(now) {
+			break
+		}
+		last = next
+		t = next
+	}
+	if last.IsZero() {
+		delete(c, expr)
+		return last, false
+	}
+	c[expr] = last
+	return last, true
+}
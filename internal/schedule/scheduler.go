@@ -0,0 +1,392 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/instances"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/servers"
+	"github.com/hashicorp/nomad/api"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var logger = logging.For("schedule")
+
+// minimums are expected to repeat at least weekly, so there is no need to look back further
+const minimumLookback = 8 * 24 * time.Hour
+
+// scaledDownKey is the key of the counts to restore in the schedule bucket, which is already per environment
+const scaledDownKey = "scaled_down"
+
+// Scheduler applies scheduled minimum instance counts and maintenance windows
+type Scheduler struct {
+	mu     sync.Mutex
+	file   *File
+	state  map[string]*TypeState
+	client *api.Client
+	nc     *nats.Conn
+	// persists the counts to restore, so they survive a restart. nil without JetStream.
+	kv jetstream.KeyValue
+	// serializes persist, so an older snapshot never overwrites a newer one
+	persistMu sync.Mutex
+	fires     fireCache
+}
+
+// NewScheduler creates a new Scheduler, picking up the counts the previous run had yet to restore.
+// A nil file means nothing is scheduled.
+func NewScheduler(nc *nats.Conn, client *api.Client, file *File) *Scheduler {
+	if file == nil {
+		file = &File{}
+	}
+	s := &Scheduler{
+		file:   file,
+		state:  make(map[string]*TypeState),
+		client: client,
+		nc:     nc,
+		fires:  make(fireCache),
+	}
+	s.load()
+	return s
+}
+
+// load opens the schedule bucket and reads the persisted counts to restore, if any
+func (s *Scheduler) load() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	js, err := jetstream.New(s.nc)
+	if err == nil {
+		s.kv, err = js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:  env.EnsurePrefixed("cydian_schedule"),
+			Storage: jetstream.FileStorage,
+		})
+	}
+	if err != nil {
+		logger.Warn("scheduled scale downs won't survive restarts, JetStream is unavailable", "err", err)
+		s.kv = nil
+		return
+	}
+
+	entry, err := s.kv.Get(ctx, scaledDownKey)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return
+	}
+	if err != nil {
+		logger.Error("failed to read the persisted scale downs", "err", err)
+		return
+	}
+	var counts map[string]int
+	if err := json.Unmarshal(entry.Value(), &counts); err != nil {
+		logger.Error("failed to decode the persisted scale downs", "err", err)
+		return
+	}
+	for name, count := range counts {
+		if _, ok := s.file.Types[name]; !ok {
+			logger.Warn("not restoring a type that is no longer scheduled", "type", name, "count", count)
+			continue
+		}
+		s.state[name] = &TypeState{ScaledDownFrom: count}
+	}
+	logger.Info("restored scheduled scale downs", "scaled_down", counts)
+}
+
+// Persistence describes the schedule bucket, failing if it can't be reached
+func (s *Scheduler) Persistence(ctx context.Context) (string, error) {
+	if s.kv == nil {
+		return "in-memory only, JetStream is unavailable", nil
+	}
+	status, err := s.kv.Status(ctx)
+	if err != nil {
+		return "", fmt.Errorf("schedule bucket: %w", err)
+	}
+	return fmt.Sprintf("bucket %s, %d bytes", status.Bucket(), status.Bytes()), nil
+}
+
+// persist writes the counts to restore to the schedule bucket. s.mu must not be held.
+func (s *Scheduler) persist() {
+	if s.kv == nil {
+		return
+	}
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	s.mu.Lock()
+	counts := make(map[string]int)
+	for name, state := range s.state {
+		if state.ScaledDownFrom > 0 {
+			counts[name] = state.ScaledDownFrom
+		}
+	}
+	s.mu.Unlock()
+	data, err := json.Marshal(counts)
+	if err != nil {
+		logger.Error("failed to marshal the scale downs", "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := s.kv.Put(ctx, scaledDownKey, data); err != nil {
+		logger.Error("failed to persist the scale downs", "err", err)
+	}
+}
+
+// SetFile replaces the schedule, ie: after the configuration was reloaded
+func (s *Scheduler) SetFile(file *File) {
+	s.mu.Lock()
+	if file == nil {
+		file = &File{}
+	}
+	s.file = file
+	clear(s.fires) // the expressions were parsed anew
+	dropped := false
+	for name, state := range s.state {
+		if _, ok := file.Types[name]; !ok {
+			dropped = dropped || state.ScaledDownFrom > 0
+			delete(s.state, name)
+		}
+	}
+	s.mu.Unlock()
+	if dropped {
+		s.persist()
+	}
+}
+
+// Run evaluates the schedule every interval, forever
+func (s *Scheduler) Run(interval time.Duration) {
+	s.Evaluate(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.Evaluate(now)
+	}
+}
+
+// Evaluate brings every scheduled type in line with the schedule at the given time. Decisions are made
+// under the lock, Nomad is only called once it is released so InMaintenance never waits on it.
+func (s *Scheduler) Evaluate(now time.Time) {
+	type started struct {
+		name   string
+		window Window
+		ends   time.Time
+	}
+	var starts []started
+	var retries []string
+	targets := make(map[string]int)
+
+	s.mu.Lock()
+	for name, t := range s.file.Types {
+		state, ok := s.state[name]
+		if !ok {
+			state = &TypeState{}
+			s.state[name] = state
+		}
+
+		state.Minimum, state.NextMinimum = currentMinimum(s.fires, t.Minimums, now)
+		window, ends, next := currentWindow(s.fires, t.Maintenance, now)
+		state.NextMaintenance = next
+
+		if window != nil {
+			state.MaintenanceEnds = &ends
+			if !state.InMaintenance {
+				state.InMaintenance = true
+				state.scaleDownDue = now.Add(window.drain)
+				starts = append(starts, started{name, *window, ends})
+			} else if s.claimScaleDownInternal(state, now) {
+				// scaling down after the drain failed, or its timer hasn't fired yet
+				retries = append(retries, name)
+			}
+			continue
+		}
+
+		if state.InMaintenance {
+			state.InMaintenance = false
+			state.MaintenanceEnds = nil
+			state.scaleDownDue = time.Time{}
+			logger.Info("maintenance window ended", "type", name, "restoring", state.ScaledDownFrom)
+		}
+		targets[name] = max(state.Minimum, state.ScaledDownFrom)
+	}
+	s.mu.Unlock()
+
+	for _, st := range starts {
+		s.startMaintenance(st.name, st.window, st.ends)
+	}
+	for _, name := range retries {
+		s.scaleDown(name)
+	}
+	for name, target := range targets {
+		if s.ensureMinimum(name, target) {
+			s.restored(name, target)
+		}
+	}
+}
+
+// Snapshot returns the schedule and the current state of every type
+func (s *Scheduler) Snapshot() ScheduleResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := make(map[string]TypeState, len(s.state))
+	for name, st := range s.state {
+		state[name] = *st
+	}
+	return ScheduleResponse{Schedule: *s.file, State: state}
+}
+
+// InMaintenance reports whether the type is inside a scheduled maintenance window
+func (s *Scheduler) InMaintenance(instanceType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.state[instanceType]
+	return ok && state.InMaintenance
+}
+
+func (s *Scheduler) startMaintenance(name string, window Window, ends time.Time) {
+	logger.Info("maintenance window started", "type", name, "drain", window.drain, "ends", ends)
+
+	data, err := json.Marshal(servers.DrainNotify{
+		Type:   name,
+		Reason: window.Message,
+		Until:  &ends,
+	})
+	if err != nil {
+		logger.Error("failed to marshal drain notification", "type", name, "err", err)
+	} else if err := s.nc.Publish(env.EnsurePrefixed("servers.drain.notify"), data); err != nil {
+		logger.Error("failed to publish drain notification", "type", name, "err", err)
+	}
+
+	time.AfterFunc(window.drain, func() {
+		// the schedule may have moved on in the meantime, or Evaluate got to it first
+		s.mu.Lock()
+		state, ok := s.state[name]
+		claimed := ok && s.claimScaleDownInternal(state, time.Now())
+		s.mu.Unlock()
+		if claimed {
+			s.scaleDown(name)
+		}
+	})
+}
+
+// claimScaleDownInternal reports whether the type is due to be scaled down for its maintenance window, and
+// nobody is at it already. The caller must then call scaleDown. s.mu must be held.
+func (s *Scheduler) claimScaleDownInternal(state *TypeState, now time.Time) bool {
+	if !state.InMaintenance || state.scaleDownDue.IsZero() || state.scalingDown || now.Before(state.scaleDownDue) {
+		return false
+	}
+	state.scalingDown = true
+	return true
+}
+
+// scaleDown scales the type to zero for its maintenance window. If that fails, Evaluate retries while the
+// window lasts.
+func (s *Scheduler) scaleDown(name string) {
+	count, errMsg := instances.GroupCount(context.Background(), s.client, name)
+	if len(errMsg) > 0 {
+		logger.Warn("failed to check the instance count, not scaling down yet", "type", name, "code", errMsg)
+		s.finishScaleDown(name, false)
+		return
+	}
+	// recorded before scaling, a restart in between must still restore it
+	s.scaledDown(name, count)
+	errMsg = instances.ScaleTo(context.Background(), s.client, name, 0, "Scheduled maintenance")
+	s.finishScaleDown(name, len(errMsg) == 0)
+}
+
+// finishScaleDown ends a claimed scale down, which is retried unless it succeeded
+func (s *Scheduler) finishScaleDown(name string, succeeded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.state[name]; ok {
+		state.scalingDown = false
+		if succeeded {
+			state.scaleDownDue = time.Time{}
+		}
+	}
+}
+
+// scaledDown records the instance count a maintenance window scaled the type down from
+func (s *Scheduler) scaledDown(name string, count int) {
+	s.mu.Lock()
+	state, ok := s.state[name]
+	changed := ok && count > state.ScaledDownFrom
+	if changed {
+		state.ScaledDownFrom = count
+	}
+	s.mu.Unlock()
+	if changed {
+		s.persist()
+	}
+}
+
+// restored forgets the pre-window count once the type was brought back up to target
+func (s *Scheduler) restored(name string, target int) {
+	s.mu.Lock()
+	state, ok := s.state[name]
+	changed := ok && !state.InMaintenance && state.ScaledDownFrom > 0 && state.ScaledDownFrom <= target
+	if changed {
+		state.ScaledDownFrom = 0
+	}
+	s.mu.Unlock()
+	if changed {
+		s.persist()
+	}
+}
+
+// ensureMinimum scales the type up if it is below its minimum, reporting whether it reached it. It never
+// scales down, so manual scale-ups survive until the type is scaled down manually.
+func (s *Scheduler) ensureMinimum(name string, minimum int) bool {
+	if minimum <= 0 {
+		return true
+	}
+	count, errMsg := instances.GroupCount(context.Background(), s.client, name)
+	if len(errMsg) > 0 {
+		logger.Warn("failed to check the instance count", "type", name, "code", errMsg)
+		return false
+	}
+	if count >= minimum {
+		return true
+	}
+	return len(instances.ScaleTo(context.Background(), s.client, name, minimum, "Scheduled minimum")) == 0
+}
+
+// currentMinimum returns the minimum of the entry that fired most recently, and when the next one fires
+func currentMinimum(fires fireCache, minimums []Minimum, now time.Time) (int, *time.Time) {
+	minimum := 0
+	var latest time.Time
+	var next *time.Time
+	for _, m := range minimums {
+		if fired, ok := fires.lastFire(m.expr, now, minimumLookback); ok && fired.After(latest) {
+			latest = fired
+			minimum = m.Min
+		}
+		if n := m.expr.Next(now); !n.IsZero() && (next == nil || n.Before(*next)) {
+			next = &n
+		}
+	}
+	return minimum, next
+}
+
+// currentWindow returns the active maintenance window and its end, if any, and when the next one starts
+func currentWindow(fires fireCache, windows []Window, now time.Time) (*Window, time.Time, *time.Time) {
+	var active *Window
+	var ends time.Time
+	var next *time.Time
+	for i, w := range windows {
+		if started, ok := fires.lastFire(w.expr, now, w.duration); ok && now.Before(started.Add(w.duration)) {
+			if end := started.Add(w.duration); end.After(ends) {
+				active = &windows[i]
+				ends = end
+			}
+		}
+		if n := w.expr.Next(now); !n.IsZero() && (next == nil || n.Before(*next)) {
+			next = &n
+		}
+	}
+	return active, ends, next
+}
@@ -0,0 +1,115 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// monday is a Monday, the day the test windows start on
+var monday = time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC)
+
+func at(day int, hour int, minute int) time.Time {
+	return monday.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+// parsed returns the schedule of a single type, as Load would
+func parsed(t *testing.T, schedule TypeSchedule) TypeSchedule {
+	t.Helper()
+	file := File{Types: map[string]TypeSchedule{"lobby": schedule}}
+	if err := file.parse(); err != nil {
+		t.Fatal(err)
+	}
+	return file.Types["lobby"]
+}
+
+func TestCurrentWindow(t *testing.T) {
+	schedule := parsed(t, TypeSchedule{Maintenance: []Window{
+		{Cron: "0 4 * * 1", Duration: "30m"}, // mondays at 04:00
+		{Cron: "15 4 * * 1", Duration: "1h"}, // overlaps the first, ending later
+		{Cron: "0 23 * * 3", Duration: "2h"}, // crosses midnight
+		{Cron: "0 12 * * 5", Duration: "1h30m"},
+	}})
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		active bool
+		ends   time.Time
+		next   time.Time
+	}{
+		{"before the first window", at(0, 3, 59), false, time.Time{}, at(0, 4, 0)},
+		{"at the start", at(0, 4, 0), true, at(0, 4, 30), at(0, 4, 15)},
+		{"overlapping windows end with the later one", at(0, 4, 20), true, at(0, 5, 15), at(2, 23, 0)},
+		{"just before the end", at(0, 5, 14), true, at(0, 5, 15), at(2, 23, 0)},
+		{"at the end", at(0, 5, 15), false, time.Time{}, at(2, 23, 0)},
+		{"before midnight", at(2, 23, 30), true, at(3, 1, 0), at(4, 12, 0)},
+		{"past midnight", at(3, 0, 59), true, at(3, 1, 0), at(4, 12, 0)},
+		{"after midnight", at(3, 1, 0), false, time.Time{}, at(4, 12, 0)},
+		{"a week later", at(7, 4, 5), true, at(7, 4, 30), at(7, 4, 15)},
+	}
+	fires := make(fireCache)
+	for _, test := range tests {
+		// every case runs with the cache warmed by the previous ones, and with a cold one
+		for _, cache := range []fireCache{fires, make(fireCache)} {
+			window, ends, next := currentWindow(cache, schedule.Maintenance, test.now)
+			if (window != nil) != test.active || !ends.Equal(test.ends) {
+				t.Errorf("%s: got active=%v until %v, want active=%v until %v", test.name, window != nil, ends, test.active, test.ends)
+			}
+			if next == nil || !next.Equal(test.next) {
+				t.Errorf("%s: got next window %v, want %v", test.name, next, test.next)
+			}
+		}
+	}
+}
+
+func TestCurrentMinimum(t *testing.T) {
+	schedule := parsed(t, TypeSchedule{Minimums: []Minimum{
+		{Cron: "0 18 * * *", Min: 6},
+		{Cron: "0 1 * * *", Min: 2},
+		{Cron: "0 9 * * 6", Min: 10}, // saturdays, a week apart
+	}})
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		minimum int
+		next    time.Time
+	}{
+		{"the evening minimum", at(0, 18, 0), 6, at(1, 1, 0)},
+		{"carried past midnight", at(0, 23, 59), 6, at(1, 1, 0)},
+		{"the night minimum", at(1, 1, 0), 2, at(1, 18, 0)},
+		{"until the next evening", at(1, 17, 59), 2, at(1, 18, 0)},
+		{"the weekly minimum", at(5, 9, 0), 10, at(5, 18, 0)},
+		{"overridden by the evening", at(5, 18, 0), 6, at(6, 1, 0)},
+		{"stepping back in time", at(0, 19, 0), 6, at(1, 1, 0)},
+	}
+	fires := make(fireCache)
+	for _, test := range tests {
+		for _, cache := range []fireCache{fires, make(fireCache)} {
+			minimum, next := currentMinimum(cache, schedule.Minimums, test.now)
+			if minimum != test.minimum {
+				t.Errorf("%s: got minimum %d, want %d", test.name, minimum, test.minimum)
+			}
+			if next == nil || !next.Equal(test.next) {
+				t.Errorf("%s: got next minimum %v, want %v", test.name, next, test.next)
+			}
+		}
+	}
+}
+
+func TestLastFireLookback(t *testing.T) {
+	schedule := parsed(t, TypeSchedule{Minimums: []Minimum{{Cron: "0 4 * * 1", Min: 1}}})
+	expr := schedule.Minimums[0].expr
+	fires := make(fireCache)
+
+	if fired, ok := fires.lastFire(expr, at(0, 4, 10), time.Hour); !ok || !fired.Equal(at(0, 4, 0)) {
+		t.Fatalf("got %v (%v), want the fire at 04:00", fired, ok)
+	}
+	// the cached fire is out of the lookback now
+	if fired, ok := fires.lastFire(expr, at(0, 5, 10), time.Hour); ok {
+		t.Fatalf("nothing fired within the hour, got %v", fired)
+	}
+	if fired, ok := fires.lastFire(expr, at(7, 4, 0), minimumLookback); !ok || !fired.Equal(at(7, 4, 0)) {
+		t.Fatalf("got %v (%v), want the fire a week later", fired, ok)
+	}
+}
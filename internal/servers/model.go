@@ -2,7 +2,10 @@ package servers
 
 import (
 	"encoding/json"
+	"slices"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // ServerInfo represents the structure of server details
@@ -12,6 +15,21 @@ type ServerInfo struct {
 	Port     int        `json:"port"`
 	ID       string     `json:"id"`
 	LastSeen *time.Time `json:"last_seen"` // pointer indicates the value may be null.
+	Draining bool       `json:"draining"`  // no new players should be sent here
+	// set on private instances, only their party and players are selected onto them
+	PartyID *uuid.UUID  `json:"party_id,omitempty"`
+	Players []uuid.UUID `json:"players,omitempty"`
+}
+
+// Admits reports whether the player, in the given party (nil if none), may be selected onto the server
+func (s ServerInfo) Admits(player uuid.UUID, party *uuid.UUID) bool {
+	if s.PartyID == nil && len(s.Players) == 0 {
+		return true
+	}
+	if s.PartyID != nil && party != nil && *s.PartyID == *party {
+		return true
+	}
+	return slices.Contains(s.Players, player)
 }
 
 type ServerList struct {
@@ -33,3 +51,16 @@ func (s ServerInfo) MarshalJSON() ([]byte, error) {
 		Alias: (*Alias)(&s),
 	})
 }
+
+// DrainNotify tells proxies to stop routing new players to a server type (or a single server, when ID is set)
+type DrainNotify struct {
+	ID     string     `json:"id,omitempty"`
+	Type   string     `json:"type"`
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until"` // nil if the drain has no scheduled end
+}
+
+type ServerResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
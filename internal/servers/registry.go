@@ -2,24 +2,46 @@ package servers
 
 import (
 	"encoding/json"
-	"log"
+	"math/rand/v2"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/metrics"
 	"github.com/CytonicMC/Cydian/internal/utils"
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 )
 
+var logger = logging.For("servers")
+
 // Registry to store active servers
 type Registry struct {
 	mu      sync.Mutex
 	servers map[string]ServerInfo
+	// keyed by server id, notified once that server registers
+	waiters map[string][]chan ServerInfo
+	// keyed by server id, the owners of private instances. Kept apart from servers so a reservation made
+	// before dispatch applies as soon as the server registers.
+	reservations map[string]reservation
+	// when HealthCheck last completed a sweep
+	lastHealthCheck time.Time
+}
+
+type reservation struct {
+	party   *uuid.UUID
+	players []uuid.UUID
 }
 
 // NewRegistry creates a new Registry instance
 func NewRegistry() *Registry {
-	return &Registry{servers: make(map[string]ServerInfo)}
+	return &Registry{
+		servers:      make(map[string]ServerInfo),
+		waiters:      make(map[string][]chan ServerInfo),
+		reservations: make(map[string]reservation),
+	}
 }
 
 // AddOrUpdate adds or updates server information in the registry
@@ -27,15 +49,114 @@ func (r *Registry) AddOrUpdate(info ServerInfo) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	info.LastSeen = utils.PointerNow()
+	if existing, ok := r.servers[info.ID]; ok {
+		info.Draining = existing.Draining // re-registering doesn't undo a drain
+	}
+	info.PartyID, info.Players = nil, nil
+	if res, ok := r.reservations[info.ID]; ok {
+		info.PartyID, info.Players = res.party, res.players
+	}
 	r.servers[info.ID] = info
-	log.Printf("Registered/Updated server: %+v", info)
+	logger.Info("registered server", "server", info.ID, "type", info.Type, "ip", info.IP, "port", info.Port)
+
+	for _, ch := range r.waiters[info.ID] {
+		ch <- info
+	}
+	delete(r.waiters, info.ID)
+}
+
+// WaitFor blocks until a server with the given id registers, or the timeout elapses.
+// If the server is already registered, it is returned immediately.
+func (r *Registry) WaitFor(id string, timeout time.Duration) (*ServerInfo, bool) {
+	r.mu.Lock()
+	if info, ok := r.servers[id]; ok {
+		r.mu.Unlock()
+		return &info, true
+	}
+	ch := make(chan ServerInfo, 1) // buffered so AddOrUpdate never blocks
+	r.waiters[id] = append(r.waiters[id], ch)
+	r.mu.Unlock()
+
+	select {
+	case info := <-ch:
+		return &info, true
+	case <-time.After(timeout):
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		waiting := r.waiters[id]
+		for i, c := range waiting {
+			if c == ch {
+				r.waiters[id] = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(r.waiters[id]) == 0 {
+			delete(r.waiters, id)
+		}
+		// it may have registered between the timeout firing and acquiring the lock
+		select {
+		case info := <-ch:
+			return &info, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// Get returns the server with the given id, if it is registered
+func (r *Registry) Get(id string) (*ServerInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.servers[id]
+	if !ok {
+		return nil, false
+	}
+	return &info, true
 }
 
 func (r *Registry) Remove(id string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	delete(r.servers, id)
-	log.Printf("Removed server: %+v", id)
+	logger.Info("removed server", "server", id)
+}
+
+// Reserve keeps the server with the given id for a party and its players, so Select never routes anyone
+// else onto it. The server doesn't have to be registered yet.
+func (r *Registry) Reserve(id string, party *uuid.UUID, players []uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := reservation{party: party, players: slices.Clone(players)}
+	r.reservations[id] = res
+	if info, ok := r.servers[id]; ok {
+		info.PartyID, info.Players = res.party, res.players
+		r.servers[id] = info
+	}
+}
+
+// Release drops the reservation of the server with the given id
+func (r *Registry) Release(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reservations, id)
+	if info, ok := r.servers[id]; ok {
+		info.PartyID, info.Players = nil, nil
+		r.servers[id] = info
+	}
+}
+
+// Drain marks a server as draining, so it is no longer selected for new players
+func (r *Registry) Drain(id string) (*ServerInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.servers[id]
+	if !ok {
+		return nil, false
+	}
+	info.Draining = true
+	r.servers[id] = info
+	logger.Info("draining server", "server", id)
+	return &info, true
 }
 
 // Cleanup removes stale servers from the registry
@@ -44,7 +165,7 @@ func (r *Registry) Cleanup(timeout time.Duration) {
 	defer r.mu.Unlock()
 	for id, info := range r.servers {
 		if time.Since(*info.LastSeen) > timeout {
-			log.Printf("Removing stale server: %s", id)
+			logger.Info("removing stale server", "server", id)
 			delete(r.servers, id)
 		}
 	}
@@ -69,20 +190,24 @@ func (r *Registry) HealthCheck(nc *nats.Conn, timeout time.Duration) {
 
 	for id, server := range r.servers {
 		subject := "health.check." + id
+		start := time.Now()
 		_, err := nc.Request(env.EnsurePrefixed(subject), nil, timeout)
+		metrics.HealthCheckDuration.Observe(time.Since(start).Seconds())
 		if err != nil {
-			log.Printf("Server %s is unresponsive, removing from registry: %v", id, err)
+			logger.Warn("server failed its health check, removing it", "server", id, "type", server.Type, "err", err)
+			metrics.HealthCheckFailures.WithLabelValues(server.Type).Inc()
 
 			serverInfo := r.servers[id]
 
 			data, err := json.Marshal(serverInfo)
 			if err != nil {
-				log.Printf("Failed to jsonify serverInfo")
+				logger.Error("failed to marshal server info", "server", id, "err", err)
 				return
 			}
 
-			err = nc.Publish(env.EnsurePrefixed("servers.proxy.shutdown.notify"), data)
-			log.Printf("Notified proxies of shutdown for server '%s' due to health check failure", serverInfo.ID)
+			if err := nc.Publish(env.EnsurePrefixed("servers.proxy.shutdown.notify"), data); err != nil {
+				logger.Error("failed to notify proxies of server shutdown", "server", id, "err", err)
+			}
 
 			delete(r.servers, id)
 			continue
@@ -91,4 +216,30 @@ func (r *Registry) HealthCheck(nc *nats.Conn, timeout time.Duration) {
 		server.LastSeen = utils.PointerNow() // Update last seen time on success
 		r.servers[id] = server
 	}
+	r.lastHealthCheck = time.Now()
+}
+
+// LastHealthCheck returns when the last health check sweep completed, or the zero time if none has yet
+func (r *Registry) LastHealthCheck() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastHealthCheck
+}
+
+// Select picks a random registered server of the given type that admits the player, who is in the given
+// party (nil if none). Private instances are only picked for their own party and players.
+func (r *Registry) Select(serverType string, player uuid.UUID, party *uuid.UUID) (*ServerInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	candidates := make([]ServerInfo, 0)
+	for _, info := range r.servers {
+		if info.Type == serverType && !info.Draining && info.Admits(player, party) {
+			candidates = append(candidates, info)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	selected := candidates[rand.IntN(len(candidates))]
+	return &selected, true
 }
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier adapts NATS headers to the propagation API. NATS header keys are case-sensitive, but
+// other services may not write traceparent in the same case, so lookups fall back to a case-insensitive match.
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	if v := nats.Header(c).Get(key); v != "" {
+		return v
+	}
+	for k, v := range c {
+		if strings.EqualFold(k, key) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// StartConsumer continues the trace carried by an incoming message, starting a server span for its subject
+func StartConsumer(ctx context.Context, subject string, msg *nats.Msg, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if msg.Header != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Header))
+	}
+	attrs = append(attrs,
+		attribute.String("messaging.system", "nats"),
+		attribute.String("messaging.destination.name", subject),
+	)
+	return tracer.Start(ctx, subject, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// Inject writes the context's trace into the message headers
+func Inject(ctx context.Context, msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Header))
+}
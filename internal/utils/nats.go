@@ -1,15 +1,146 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CytonicMC/Cydian/internal/config"
+	"github.com/CytonicMC/Cydian/internal/env"
+	"github.com/CytonicMC/Cydian/internal/health"
+	"github.com/CytonicMC/Cydian/internal/logging"
+	"github.com/CytonicMC/Cydian/internal/metrics"
+	"github.com/CytonicMC/Cydian/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
 )
 
-func NatsUrl() string {
-	username := os.Getenv("NATS_USERNAME")
-	password := os.Getenv("NATS_PASSWORD")
-	hostname := os.Getenv("NATS_HOSTNAME")
-	port := os.Getenv("NATS_PORT")
+var logger = logging.For("nats")
+
+// probeSubject is answered by this connection alone, see SubscribeProbe
+var probeSubject atomic.Value
+
+// NatsUrl returns the comma separated seed URLs. Credentials are passed as connection options instead,
+// so the URL is safe to log.
+func NatsUrl(cfg config.NatsConfig) string {
+	if len(cfg.Servers) > 0 {
+		return strings.Join(cfg.Servers, ",")
+	}
+	return fmt.Sprintf("nats://%s:%s", cfg.Hostname, cfg.Port)
+}
+
+// ConnectNats connects to NATS using the configured authentication and TLS settings. Disconnects,
+// reconnects and asynchronous errors are logged, counted and reflected in the service health.
+func ConnectNats(cfg config.NatsConfig) (*nats.Conn, error) {
+	opts := []nats.Option{
+		nats.Name("Cydian"),
+		nats.MaxReconnects(cfg.MaxReconnects),
+		nats.ReconnectWait(cfg.ReconnectWait.D()),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			logger.Warn("disconnected from NATS", "err", err)
+			metrics.NatsConnected.Set(0)
+			health.SetNatsConnected(false)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Info("reconnected to NATS", "url", nc.ConnectedUrlRedacted())
+			metrics.NatsReconnects.Inc()
+			metrics.NatsConnected.Set(1)
+			// verify off the callback goroutine, flushing blocks until the server answers
+			go verifySubscriptions(nc)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			logger.Error("NATS connection closed", "err", nc.LastError())
+			metrics.NatsConnected.Set(0)
+			health.SetNatsConnected(false)
+		}),
+		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			metrics.NatsErrors.Inc()
+			if sub != nil {
+				logger.Error("NATS subscription error", "subject", sub.Subject, "err", err)
+				return
+			}
+			logger.Error("NATS error", "err", err)
+		}),
+	}
+
+	switch {
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	case cfg.NkeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(cfg.NkeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case cfg.Username != "":
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+
+	if cfg.TLS.CAFile != "" {
+		opts = append(opts, nats.RootCAs(cfg.TLS.CAFile))
+	}
+	if cfg.TLS.CertFile != "" {
+		opts = append(opts, nats.ClientCert(cfg.TLS.CertFile, cfg.TLS.KeyFile))
+	}
+
+	nc, err := nats.Connect(NatsUrl(cfg), opts...)
+	if err != nil {
+		return nil, err
+	}
+	metrics.NatsConnected.Set(1)
+	health.SetNatsConnected(true)
+	return nc, nil
+}
+
+// SubscribeProbe subscribes to a subject only this connection answers, so a request to it proves the server
+// routes this connection's subscriptions. Call it once every handler is subscribed: subscriptions are
+// replayed in order after a reconnect, so the probe answering means the handlers are back too.
+func SubscribeProbe(nc *nats.Conn) error {
+	subject := env.EnsurePrefixed("cydian.probe." + uuid.NewString())
+	if _, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		_ = msg.Respond(nil)
+	}); err != nil {
+		return err
+	}
+	probeSubject.Store(subject)
+	return nil
+}
+
+// ProbeSubscriptions makes a round trip through the server to the probe subscription
+func ProbeSubscriptions(nc *nats.Conn, timeout time.Duration) error {
+	subject, ok := probeSubject.Load().(string)
+	if !ok {
+		return errors.New("the probe isn't subscribed yet")
+	}
+	_, err := nc.Request(subject, nil, timeout)
+	return err
+}
+
+// verifySubscriptions marks NATS healthy again once the probe answers, retrying with backoff while the
+// connection stays up. If it drops again, the next reconnect starts over.
+func verifySubscriptions(nc *nats.Conn) {
+	backoff := time.Second
+	for {
+		err := ProbeSubscriptions(nc, 5*time.Second)
+		if err == nil {
+			health.SetNatsConnected(true)
+			return
+		}
+		if !nc.IsConnected() {
+			return
+		}
+		logger.Warn("subscriptions aren't answering after reconnecting, retrying", "err", err, "retry_in", backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
 
-	return fmt.Sprintf("nats://%s:%s@%s:%s", username, password, hostname, port)
+// Publish publishes data to subject, carrying the context's correlation ID and trace in the message headers
+func Publish(ctx context.Context, nc *nats.Conn, subject string, data []byte) error {
+	msg := logging.NewMsg(ctx, subject, data)
+	tracing.Inject(ctx, msg)
+	return nc.PublishMsg(msg)
 }